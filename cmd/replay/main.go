@@ -0,0 +1,121 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	utils "github.com/gargous/flitter/common"
+	"github.com/gargous/flitter/core"
+	"github.com/gargous/flitter/servers"
+	"io"
+	"os"
+	"time"
+)
+
+/*
+push the messages of a recording made with -record into one service, at the pace they were recorded
+
+	go run main.go -v -f scene.rec -s scene -p scene@127.0.0.1:8000
+*/
+func main() {
+	filename := flag.String("f", "", "-f [the recording]")
+	srvname := flag.String("s", "scene", "-s [name|watch|heartbeat|scene]")
+	npath := flag.String("p", "replay@127.0.0.1:9000", "-p [the node path the service runs at]")
+	speed := flag.Float64("speed", 1, "-speed [times of the recorded pace, 0 for no waiting]")
+	sent := flag.Bool("sent", false, "replay the messages sent by the node too")
+	wait := flag.Int("wait", 1000, "-wait [milliseconds to drain the service after the last message]")
+	dump := flag.Bool("dump", false, "print the records as json lines instead of replaying them")
+	servers.Lauch()
+
+	if *dump {
+		dumpRecords(*filename)
+		return
+	}
+
+	st, srvice, err := newService(*srvname)
+	utils.ErrQuit(err, "Replay")
+	//nothing the service sends leaves the replay
+	server := newStubServer(core.NodePath(*npath))
+	server.ConfigService(st, srvice)
+	err = srvice.Init(server)
+	utils.ErrQuit(err, "Replay Init "+st.String())
+	go srvice.Start()
+
+	file, err := os.Open(*filename)
+	utils.ErrQuit(err, "Replay Open")
+	defer file.Close()
+	reader, err := core.NewRecordReader(file)
+	utils.ErrQuit(err, "Replay Read")
+
+	utils.Logf(utils.Norf, "Start Replay %s Into %v", *filename, st)
+	var last time.Time
+	count := 0
+	for {
+		record, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		if _, ok := err.(*core.RecordError); ok {
+			utils.Logf(utils.Warningf, "Replay Skip %v", err)
+			continue
+		}
+		utils.ErrQuit(err, "Replay Read")
+		if record.Sent && !*sent {
+			continue
+		}
+		target, ok := record.Msg.GetInfo().GetHeader(core.HK_Service)
+		if ok && target != st.String() {
+			continue
+		}
+		if *speed > 0 && !last.IsZero() && record.Time.After(last) {
+			time.Sleep(time.Duration(float64(record.Time.Sub(last)) / *speed))
+		}
+		last = record.Time
+		utils.Logf(utils.Infof, "Replay %v From %s", record.Msg, record.Peer)
+		srvice.Push(record.Msg)
+		count++
+	}
+	err = server.Shutdown(time.Duration(*wait))
+	if err != nil {
+		utils.ErrIn(err, "Replay Drain")
+	}
+	utils.Logf(utils.Norf, "End Replay %d Messages", count)
+}
+
+func dumpRecords(filename string) {
+	file, err := os.Open(filename)
+	utils.ErrQuit(err, "Dump Open")
+	defer file.Close()
+	reader, err := core.NewRecordReader(file)
+	utils.ErrQuit(err, "Dump Read")
+	encoder := json.NewEncoder(os.Stdout)
+	for {
+		record, err := reader.Next()
+		if err == io.EOF {
+			return
+		}
+		if _, ok := err.(*core.RecordError); ok {
+			utils.Logf(utils.Warningf, "Dump Skip %v", err)
+			continue
+		}
+		utils.ErrQuit(err, "Dump Read")
+		err = encoder.Encode(record)
+		utils.ErrQuit(err, "Dump Encode")
+	}
+}
+
+func newService(name string) (st servers.ServiceType, srvice servers.Service, err error) {
+	switch name {
+	case "name":
+		st, srvice = servers.ST_Name, servers.NewNameService()
+	case "watch":
+		st, srvice = servers.ST_Watch, servers.NewWatchService()
+	case "heartbeat":
+		st, srvice = servers.ST_HeartBeat, servers.NewHeartbeatService()
+	case "scene":
+		st, srvice = servers.ST_Scence, servers.NewScenceService()
+	default:
+		err = errors.New("No Service " + name)
+	}
+	return
+}
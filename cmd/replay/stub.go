@@ -0,0 +1,114 @@
+package main
+
+import (
+	"errors"
+	utils "github.com/gargous/flitter/common"
+	"github.com/gargous/flitter/core"
+	"github.com/gargous/flitter/servers"
+	socketio "github.com/googollee/go-socket.io"
+	"io"
+	"time"
+)
+
+var ErrReplayOnly error = errors.New("Not Sent In Replay")
+
+/*
+the server the replayed service runs in, as a worker or a referee,
+what the service sends is logged instead of sent, so the replay stays off the cluster
+*/
+type stubServer struct {
+	utils.DataSet
+	path   core.NodePath
+	st     servers.ServiceType
+	srvice servers.Service
+}
+
+func newStubServer(npath core.NodePath) *stubServer {
+	return &stubServer{
+		DataSet: utils.NewDataSet(),
+		path:    npath,
+	}
+}
+
+func (s *stubServer) drop(msg core.Message, to string) error {
+	utils.Logf(utils.Infof, "Replay Drop %v To %s", msg, to)
+	return nil
+}
+
+func (s *stubServer) SendToReferee(msg core.Message, npath core.NodePath) error {
+	return s.drop(msg, string(npath))
+}
+func (s *stubServer) SendToWroker(msg core.Message, npath core.NodePath) error {
+	return s.drop(msg, string(npath))
+}
+func (s *stubServer) PublishToWorker(msg core.Message) error {
+	return s.drop(msg, "workers")
+}
+func (s *stubServer) Request(msg core.Message, npath core.NodePath, timeout time.Duration) (core.Message, error) {
+	s.drop(msg, string(npath))
+	return nil, ErrReplayOnly
+}
+func (s *stubServer) RequestReferee(msg core.Message, npath core.NodePath, timeout time.Duration) (core.Message, error) {
+	s.drop(msg, string(npath))
+	return nil, ErrReplayOnly
+}
+func (s *stubServer) SendStream(msg core.Message, npath core.NodePath, reader io.Reader) error {
+	s.drop(msg, string(npath))
+	return ErrReplayOnly
+}
+func (s *stubServer) OpenStream(msg core.Message) (io.ReadCloser, error) {
+	return nil, ErrReplayOnly
+}
+func (s *stubServer) AbortStream(msg core.Message) {}
+func (s *stubServer) SubscribeWorker(npath core.NodePath) error {
+	return nil
+}
+
+func (s *stubServer) SetPath(path core.NodePath) {
+	s.path = path
+}
+func (s *stubServer) GetPath() core.NodePath {
+	return s.path
+}
+func (s *stubServer) Start() error {
+	return nil
+}
+func (s *stubServer) InitClientHandler(cb func()) error {
+	return nil
+}
+func (s *stubServer) OnClient(event string, handler func(so socketio.Socket) interface{}) {}
+func (s *stubServer) ConfigService(st servers.ServiceType, srvice servers.Service) {
+	s.st, s.srvice = st, srvice
+}
+
+/** the replayed service gets its own messages back, the others are dropped */
+func (s *stubServer) SendService(st servers.ServiceType, msg core.Message) error {
+	if st != s.st || s.srvice == nil {
+		return s.drop(msg, st.String())
+	}
+	s.srvice.Push(msg)
+	return nil
+}
+func (s *stubServer) GetClientSocket() *socketio.Server {
+	return nil
+}
+func (s *stubServer) SetCompression(threshold int)            {}
+func (s *stubServer) SetSecret(secret []byte)                 {}
+func (s *stubServer) SetRecorder(recorder core.Recorder)      {}
+func (s *stubServer) SetRestart(policy servers.RestartPolicy) {}
+func (s *stubServer) Shutdown(timeout time.Duration) error {
+	if s.srvice == nil {
+		return nil
+	}
+	return s.srvice.Drain(timeout)
+}
+func (s *stubServer) Healthy() bool {
+	return true
+}
+func (s *stubServer) Stats() map[servers.ServiceType]core.LooperStats {
+	stats := make(map[servers.ServiceType]core.LooperStats)
+	if s.srvice != nil {
+		stats[s.st] = s.srvice.Stats()
+	}
+	return stats
+}
@@ -0,0 +1,127 @@
+package common
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"sync"
+)
+
+/*the content types of the built in codecs*/
+const (
+	CT_Gob     string = "gob"
+	CT_JSON    string = "json"
+	CT_MsgPack string = "msgpack"
+	CT_Raw     string = "raw"
+)
+
+/*turn the values into the Data of a DataItem and back*/
+type ValueCodec interface {
+	ContentType() string
+	Marshal(value interface{}) ([]byte, error)
+	Unmarshal(buf []byte, value interface{}) error
+}
+
+var (
+	__codecMutex sync.RWMutex
+	__codecs     map[string]ValueCodec = make(map[string]ValueCodec)
+)
+
+func init() {
+	RegisterCodec(gobCodec{})
+	RegisterCodec(jsonCodec{})
+	RegisterCodec(msgpackCodec{})
+	RegisterCodec(rawCodec{})
+}
+
+/** the codec replaces the one with the same content type */
+func RegisterCodec(codec ValueCodec) {
+	__codecMutex.Lock()
+	defer __codecMutex.Unlock()
+	__codecs[codec.ContentType()] = codec
+}
+
+/** the items written before the content type exists are gob */
+func GetCodec(contentType string) (codec ValueCodec, ok bool) {
+	if contentType == "" {
+		contentType = CT_Gob
+	}
+	__codecMutex.RLock()
+	defer __codecMutex.RUnlock()
+	codec, ok = __codecs[contentType]
+	return
+}
+
+type gobCodec struct {
+}
+
+func (g gobCodec) ContentType() string {
+	return CT_Gob
+}
+func (g gobCodec) Marshal(value interface{}) ([]byte, error) {
+	buffer := bytes.NewBuffer(nil)
+	err := gob.NewEncoder(buffer).Encode(value)
+	return buffer.Bytes(), err
+}
+func (g gobCodec) Unmarshal(buf []byte, value interface{}) error {
+	return gob.NewDecoder(bytes.NewBuffer(buf)).Decode(value)
+}
+
+type jsonCodec struct {
+}
+
+func (j jsonCodec) ContentType() string {
+	return CT_JSON
+}
+func (j jsonCodec) Marshal(value interface{}) ([]byte, error) {
+	return json.Marshal(value)
+}
+func (j jsonCodec) Unmarshal(buf []byte, value interface{}) error {
+	return json.Unmarshal(buf, value)
+}
+
+type msgpackCodec struct {
+}
+
+func (m msgpackCodec) ContentType() string {
+	return CT_MsgPack
+}
+func (m msgpackCodec) Marshal(value interface{}) ([]byte, error) {
+	return MsgPackMarshal(value)
+}
+func (m msgpackCodec) Unmarshal(buf []byte, value interface{}) error {
+	return MsgPackUnmarshal(buf, value)
+}
+
+/*only the bytes and the strings, kept as they are*/
+type rawCodec struct {
+}
+
+func (r rawCodec) ContentType() string {
+	return CT_Raw
+}
+func (r rawCodec) Marshal(value interface{}) (buf []byte, err error) {
+	switch valued := value.(type) {
+	case []byte:
+		buf = append([]byte{}, valued...)
+	case string:
+		buf = []byte(valued)
+	default:
+		err = errors.New("Raw Codec Wants []byte Or string")
+	}
+	return
+}
+func (r rawCodec) Unmarshal(buf []byte, value interface{}) (err error) {
+	switch valued := value.(type) {
+	case *[]byte:
+		*valued = append([]byte{}, buf...)
+	case *string:
+		*valued = string(buf)
+	case *interface{}:
+		*valued = append([]byte{}, buf...)
+	default:
+		err = errors.New("Raw Codec Wants *[]byte Or *string")
+	}
+	return
+}
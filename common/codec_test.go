@@ -0,0 +1,123 @@
+package common
+
+import (
+	"bytes"
+	"encoding/gob"
+	"github.com/kr/pretty"
+	"testing"
+)
+
+type TestCodecData struct {
+	Name  string
+	Pos   []float64
+	Tags  map[string]int
+	Alive bool
+	Skip  string `msgpack:"-"`
+}
+
+func Test_MsgPack(t *testing.T) {
+	t.Log(Norf("MsgPack Start"))
+	data := TestCodecData{
+		Name:  "tom",
+		Pos:   []float64{1.5, -2, 300000},
+		Tags:  map[string]int{"a": 1, "b": -70000},
+		Alive: true,
+		Skip:  "not sent",
+	}
+	buf, err := MsgPackMarshal(data)
+	if err != nil {
+		t.Fatal(Errf("Marshal Err:%v", err))
+	}
+	var out TestCodecData
+	err = MsgPackUnmarshal(buf, &out)
+	if err != nil {
+		t.Fatal(Errf("Unmarshal Err:%v", err))
+	}
+	data.Skip = ""
+	diff := pretty.Diff(data, out)
+	if len(diff) > 0 {
+		t.Fatal(Errf("MsgPack Lost:%v", diff))
+	}
+	var generic interface{}
+	err = MsgPackUnmarshal(buf, &generic)
+	if err != nil {
+		t.Fatal(Errf("Unmarshal Generic Err:%v", err))
+	}
+	if generic.(map[string]interface{})["Name"] != "tom" {
+		t.Fatal(Errf("MsgPack Generic Lost:%v", generic))
+	}
+	//{"a":1} by another msgpack writer
+	err = MsgPackUnmarshal([]byte{0x81, 0xa1, 'a', 0x01}, &generic)
+	if err != nil || generic.(map[string]interface{})["a"] != int64(1) {
+		t.Fatal(Errf("MsgPack Foreign Err:%v,%v", err, generic))
+	}
+	//an array in an array in an array ... is refused before the stack runs out
+	err = MsgPackUnmarshal(bytes.Repeat([]byte{0x91}, 100000), &generic)
+	if err != ErrMsgPackTooDeep {
+		t.Fatal(Errf("MsgPack Should Be Too Deep:%v", err))
+	}
+	nested := append(bytes.Repeat([]byte{0x91}, __MsgPackMaxDepth), 0x01)
+	err = MsgPackUnmarshal(nested, &generic)
+	if err != nil {
+		t.Fatal(Errf("MsgPack Nested Err:%v", err))
+	}
+	t.Log(Norf("MsgPack End"))
+}
+
+func Test_DataItem_Codec(t *testing.T) {
+	t.Log(Norf("DataItem Codec Start"))
+	var item DataItem
+	err := item.Encode(CT_JSON, map[string]int{"x": 1})
+	if err != nil || item.ContentType != CT_JSON || string(item.Data) != `{"x":1}` {
+		t.Fatal(Errf("Encode Err:%v,%v", err, item))
+	}
+	packed, err := item.Convert(CT_MsgPack)
+	if err != nil {
+		t.Fatal(Errf("Convert Err:%v", err))
+	}
+	var out map[string]int
+	err = packed.Decode(&out)
+	if err != nil || out["x"] != 1 {
+		t.Fatal(Errf("Decode Err:%v,%v", err, out))
+	}
+	buf, err := packed.Bytes()
+	if err != nil {
+		t.Fatal(Errf("Bytes Err:%v", err))
+	}
+	var back DataItem
+	err = back.Parse(buf)
+	if err != nil || back.ContentType != CT_MsgPack {
+		t.Fatal(Errf("Parse Err:%v,%v", err, back))
+	}
+	var rawItem DataItem
+	err = rawItem.Parse([]byte("hello"))
+	if err != nil || rawItem.ContentType != CT_Raw || rawItem.Version != 0 || string(rawItem.Data) != "hello" {
+		t.Fatal(Errf("Raw Misread:%v,%v", err, rawItem))
+	}
+	//as Bytes() wrote it before the mark
+	legacy := bytes.NewBuffer(nil)
+	gob.NewEncoder(legacy).Encode(struct {
+		Data    []byte
+		Version uint32
+	}{[]byte("x"), 3})
+	var legacyItem DataItem
+	err = legacyItem.Parse(legacy.Bytes())
+	if err != nil || legacyItem.Version != 3 || string(legacyItem.Data) != "x" {
+		t.Fatal(Errf("Legacy Misread:%v,%v", err, legacyItem))
+	}
+	var gobItem DataItem
+	err = gobItem.Parse(map[string]DataItem{"hp": {Data: []byte("9")}})
+	if err != nil || gobItem.ContentType != CT_Gob {
+		t.Fatal(Errf("Gob Err:%v,%v", err, gobItem))
+	}
+	jsoned, err := gobItem.Convert(CT_JSON)
+	if err != nil || string(jsoned.Data) != `{"hp":{"Data":"OQ==","Version":0,"ContentType":""}}` {
+		t.Fatal(Errf("Gob Convert Err:%v,%s", err, jsoned.Data))
+	}
+	var items map[string]DataItem
+	err = gobItem.Decode(&items)
+	if err != nil || string(items["hp"].Data) != "9" {
+		t.Fatal(Errf("Gob Decode Err:%v,%v", err, items))
+	}
+	t.Log(Norf("DataItem Codec End"))
+}
@@ -5,89 +5,181 @@ import (
 	"encoding/gob"
 	"errors"
 	"fmt"
+	"reflect"
 	"strings"
 	"sync"
 )
 
 func init() {
-	gob.Register([]byte{})
-	gob.Register(DataItem{})
-	gob.Register(map[string]DataItem{})
-	gob.Register(map[string]uint32{})
-	gob.Register(BaseDataSet{})
+	RegisterGobType([]byte{})
+	RegisterGobType(DataItem{})
+	RegisterGobType(map[string]DataItem{})
+	RegisterGobType(map[string]uint32{})
+	RegisterGobType(BaseDataSet{})
+	for _, basic := range []interface{}{"", int(0), float64(0), false, []string{}, []int{}, map[string]string{}, map[string]int{}} {
+		__gobTypes = append(__gobTypes, reflect.TypeOf(basic))
+	}
+}
+
+/*the gob data does not name its type, so the gob items convert by the first of them reading the whole data*/
+var (
+	__gobTypesMutex sync.RWMutex
+	__gobTypes      []reflect.Type
+)
+
+/** gob.Register the type of value, and let the gob items of it convert to the other content types */
+func RegisterGobType(value interface{}) {
+	gob.Register(value)
+	__gobTypesMutex.Lock()
+	defer __gobTypesMutex.Unlock()
+	__gobTypes = append(__gobTypes, reflect.TypeOf(value))
 }
 
+/*
+the Data is written by the codec of the ContentType, gob when it is empty,
+and Bytes() wraps the whole item behind __itemMark so Parse never mistakes raw bytes for an item,
+the unmarked items written before are still read
+*/
 type DataItem struct {
-	Data    []byte
-	Version uint32
-	buffer  *bytes.Buffer
+	Data        []byte
+	Version     uint32
+	ContentType string
+	buffer      *bytes.Buffer
 }
 
+var __itemMark []byte = []byte{0xFD, 'D', 'I'}
+
 func (d *DataItem) Parse(value interface{}) (err error) {
 	if value == nil {
 		err = errors.New("Value is Nil")
 		return
 	}
-	if d.buffer == nil {
-		d.buffer = bytes.NewBuffer(nil)
-	} else {
-		d.buffer.Reset()
-	}
 	switch valued := value.(type) {
 	case []byte:
-		_, err = d.buffer.Write(valued)
-		if err != nil {
-			return
-		}
-		err = gob.NewDecoder(d.buffer).Decode(d)
-		if err != nil {
-			d.Data = valued
-			d.Version = 0
-			err = nil
-		}
+		err = d.parseBytes(valued)
 	case string:
-		_, err = d.buffer.Write([]byte(valued))
-		if err != nil {
-			return
-		}
-		err = gob.NewDecoder(d.buffer).Decode(d)
-		if err != nil {
-			d.Data = []byte(valued)
-			d.Version = 0
-			err = nil
-		}
+		err = d.parseBytes([]byte(valued))
 	case DataItem:
 		d.Data = valued.Data
 		d.Version = valued.Version
+		d.ContentType = valued.ContentType
+	case *DataItem:
+		d.Data = valued.Data
+		d.Version = valued.Version
+		d.ContentType = valued.ContentType
 	default:
-		err = gob.NewEncoder(d.buffer).Encode(valued)
-		if err != nil {
+		err = d.Encode(CT_Gob, valued)
+		d.Version = 0
+	}
+	return
+}
+
+/*the item as Bytes() wrote it before the mark and the content type*/
+type legacyDataItem struct {
+	Data    []byte
+	Version uint32
+}
+
+func (d *DataItem) parseBytes(buf []byte) (err error) {
+	if !bytes.HasPrefix(buf, __itemMark) {
+		var legacy legacyDataItem
+		reader := bytes.NewReader(buf)
+		if gob.NewDecoder(reader).Decode(&legacy) == nil && reader.Len() == 0 {
+			d.Data = legacy.Data
+			d.Version = legacy.Version
+			d.ContentType = ""
 			return
 		}
-		err = gob.NewDecoder(d.buffer).Decode(d)
-		if err != nil {
-			d.buffer.Reset()
-			err = gob.NewEncoder(d.buffer).Encode(valued)
-			if err != nil {
-				return
-			}
-			d.Data = d.buffer.Bytes()
-			d.Version = 0
-			err = nil
+		d.Data = buf
+		d.Version = 0
+		d.ContentType = CT_Raw
+		return
+	}
+	var item DataItem
+	err = gob.NewDecoder(bytes.NewBuffer(buf[len(__itemMark):])).Decode(&item)
+	if err != nil {
+		return
+	}
+	d.Data = item.Data
+	d.Version = item.Version
+	d.ContentType = item.ContentType
+	return
+}
+
+/** write the value into Data by the codec of the contentType */
+func (d *DataItem) Encode(contentType string, value interface{}) (err error) {
+	codec, ok := GetCodec(contentType)
+	if !ok {
+		err = errors.New("No Codec For " + contentType)
+		return
+	}
+	buf, err := codec.Marshal(value)
+	if err != nil {
+		return
+	}
+	d.Data = buf
+	d.ContentType = codec.ContentType()
+	return
+}
+
+/** read Data into the value by the codec of the ContentType */
+func (d DataItem) Decode(value interface{}) (err error) {
+	codec, ok := GetCodec(d.ContentType)
+	if !ok {
+		err = errors.New("No Codec For " + d.ContentType)
+		return
+	}
+	return codec.Unmarshal(d.Data, value)
+}
+
+/** the same item written by another codec, the gob items only convert when their type was given to RegisterGobType */
+func (d DataItem) Convert(contentType string) (item DataItem, err error) {
+	item.Version = d.Version
+	from, _ := GetCodec(d.ContentType)
+	to, ok := GetCodec(contentType)
+	if !ok {
+		err = errors.New("No Codec For " + contentType)
+		return
+	}
+	if from != nil && from.ContentType() == to.ContentType() {
+		item.Data = d.Data
+		item.ContentType = to.ContentType()
+		return
+	}
+	var value interface{}
+	if from == nil || from.ContentType() == CT_Gob {
+		value, err = d.decodeGob()
+	} else {
+		err = d.Decode(&value)
+	}
+	if err != nil {
+		return
+	}
+	err = item.Encode(contentType, value)
+	return
+}
+
+func (d DataItem) decodeGob() (value interface{}, err error) {
+	__gobTypesMutex.RLock()
+	defer __gobTypesMutex.RUnlock()
+	for _, gobType := range __gobTypes {
+		target := reflect.New(gobType)
+		reader := bytes.NewReader(d.Data)
+		if gob.NewDecoder(reader).Decode(target.Interface()) == nil && reader.Len() == 0 {
+			return target.Elem().Interface(), nil
 		}
 	}
+	err = errors.New("No Registered Gob Type For The Item")
 	return
 }
+
 func (d *DataItem) Bytes() (buf []byte, err error) {
-	if d.buffer != nil {
-		buf = d.buffer.Bytes()
-		if len(buf) > 0 {
-			return
-		}
-		d.buffer.Reset()
-	} else {
+	if d.buffer == nil {
 		d.buffer = bytes.NewBuffer(nil)
+	} else {
+		d.buffer.Reset()
 	}
+	d.buffer.Write(__itemMark)
 	ecoder := gob.NewEncoder(d.buffer)
 	err = ecoder.Encode(d)
 	if err != nil {
@@ -102,9 +194,9 @@ func (d DataItem) String() string {
 	if len(d.Data) > showLen {
 		dstrstart := fmt.Sprintf("%v", d.Data[:showLen])
 		dstrend := fmt.Sprintf("%v", d.Data[len(d.Data)-showLen:])
-		return fmt.Sprintf("[%v ... %v],%d", dstrstart[1:len(dstrstart)-1], dstrend[1:len(dstrend)-1], d.Version)
+		return fmt.Sprintf("[%v ... %v],%d,%s", dstrstart[1:len(dstrstart)-1], dstrend[1:len(dstrend)-1], d.Version, d.ContentType)
 	} else {
-		return fmt.Sprintf("%v,%d", d.Data, d.Version)
+		return fmt.Sprintf("%v,%d,%s", d.Data, d.Version, d.ContentType)
 	}
 
 }
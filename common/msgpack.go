@@ -0,0 +1,617 @@
+package common
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+)
+
+/*
+a small msgpack codec for the DataItems read by the clients not written in go,
+it knows nil, bool, the numbers, string, bin, array and map,
+and writes the structs as maps keyed by the field names (or their msgpack tags)
+*/
+func MsgPackMarshal(value interface{}) ([]byte, error) {
+	buffer := bytes.NewBuffer(nil)
+	err := msgpackPack(buffer, reflect.ValueOf(value))
+	return buffer.Bytes(), err
+}
+
+func MsgPackUnmarshal(buf []byte, value interface{}) (err error) {
+	target := reflect.ValueOf(value)
+	if target.Kind() != reflect.Ptr || target.IsNil() {
+		err = fmt.Errorf("MsgPack Cannot Unmarshal Into %T", value)
+		return
+	}
+	reader := &msgpackReader{buf: buf}
+	decoded, err := reader.next()
+	if err != nil {
+		return
+	}
+	return msgpackAssign(target.Elem(), decoded)
+}
+
+func msgpackPack(w *bytes.Buffer, v reflect.Value) (err error) {
+	if !v.IsValid() {
+		w.WriteByte(0xc0)
+		return
+	}
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			w.WriteByte(0xc0)
+			return
+		}
+		return msgpackPack(w, v.Elem())
+	case reflect.Bool:
+		if v.Bool() {
+			w.WriteByte(0xc3)
+		} else {
+			w.WriteByte(0xc2)
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		msgpackPackInt(w, v.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		msgpackPackUint(w, v.Uint())
+	case reflect.Float32:
+		w.WriteByte(0xca)
+		binary.Write(w, binary.BigEndian, math.Float32bits(float32(v.Float())))
+	case reflect.Float64:
+		w.WriteByte(0xcb)
+		binary.Write(w, binary.BigEndian, math.Float64bits(v.Float()))
+	case reflect.String:
+		msgpackPackHead(w, len(v.String()), 0xa0, 32, 0xd9, 0xda, 0xdb)
+		w.WriteString(v.String())
+	case reflect.Slice, reflect.Array:
+		if v.Kind() == reflect.Slice && v.IsNil() {
+			w.WriteByte(0xc0)
+			return
+		}
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			buf := make([]byte, v.Len())
+			reflect.Copy(reflect.ValueOf(buf), v)
+			msgpackPackHead(w, len(buf), 0, 0, 0xc4, 0xc5, 0xc6)
+			w.Write(buf)
+			return
+		}
+		msgpackPackHead(w, v.Len(), 0x90, 16, 0, 0xdc, 0xdd)
+		for i := 0; i < v.Len(); i++ {
+			err = msgpackPack(w, v.Index(i))
+			if err != nil {
+				return
+			}
+		}
+	case reflect.Map:
+		if v.IsNil() {
+			w.WriteByte(0xc0)
+			return
+		}
+		keys := v.MapKeys()
+		sort.Slice(keys, func(i, j int) bool {
+			return fmt.Sprint(keys[i].Interface()) < fmt.Sprint(keys[j].Interface())
+		})
+		msgpackPackHead(w, len(keys), 0x80, 16, 0, 0xde, 0xdf)
+		for _, key := range keys {
+			err = msgpackPack(w, key)
+			if err != nil {
+				return
+			}
+			err = msgpackPack(w, v.MapIndex(key))
+			if err != nil {
+				return
+			}
+		}
+	case reflect.Struct:
+		names, fields := msgpackFields(v)
+		msgpackPackHead(w, len(names), 0x80, 16, 0, 0xde, 0xdf)
+		for i, name := range names {
+			msgpackPackHead(w, len(name), 0xa0, 32, 0xd9, 0xda, 0xdb)
+			w.WriteString(name)
+			err = msgpackPack(w, fields[i])
+			if err != nil {
+				return
+			}
+		}
+	default:
+		err = errors.New("MsgPack Cannot Marshal " + v.Type().String())
+	}
+	return
+}
+
+/** write the head of a sized value, a zero fix or size8 means the format has none */
+func msgpackPackHead(w *bytes.Buffer, size int, fix byte, fixLimit int, size8 byte, size16 byte, size32 byte) {
+	switch {
+	case fix != 0 && size < fixLimit:
+		w.WriteByte(fix | byte(size))
+	case size8 != 0 && size <= math.MaxUint8:
+		w.WriteByte(size8)
+		w.WriteByte(byte(size))
+	case size <= math.MaxUint16:
+		w.WriteByte(size16)
+		binary.Write(w, binary.BigEndian, uint16(size))
+	default:
+		w.WriteByte(size32)
+		binary.Write(w, binary.BigEndian, uint32(size))
+	}
+}
+
+func msgpackPackInt(w *bytes.Buffer, i int64) {
+	switch {
+	case i >= 0:
+		msgpackPackUint(w, uint64(i))
+	case i >= -32:
+		w.WriteByte(byte(int8(i)))
+	case i >= math.MinInt8:
+		w.WriteByte(0xd0)
+		w.WriteByte(byte(int8(i)))
+	case i >= math.MinInt16:
+		w.WriteByte(0xd1)
+		binary.Write(w, binary.BigEndian, int16(i))
+	case i >= math.MinInt32:
+		w.WriteByte(0xd2)
+		binary.Write(w, binary.BigEndian, int32(i))
+	default:
+		w.WriteByte(0xd3)
+		binary.Write(w, binary.BigEndian, i)
+	}
+}
+
+func msgpackPackUint(w *bytes.Buffer, u uint64) {
+	switch {
+	case u < 128:
+		w.WriteByte(byte(u))
+	case u <= math.MaxUint8:
+		w.WriteByte(0xcc)
+		w.WriteByte(byte(u))
+	case u <= math.MaxUint16:
+		w.WriteByte(0xcd)
+		binary.Write(w, binary.BigEndian, uint16(u))
+	case u <= math.MaxUint32:
+		w.WriteByte(0xce)
+		binary.Write(w, binary.BigEndian, uint32(u))
+	default:
+		w.WriteByte(0xcf)
+		binary.Write(w, binary.BigEndian, u)
+	}
+}
+
+func msgpackFields(v reflect.Value) (names []string, fields []reflect.Value) {
+	vtype := v.Type()
+	for i := 0; i < vtype.NumField(); i++ {
+		field := vtype.Field(i)
+		if field.PkgPath != "" {
+			continue
+		}
+		name := field.Name
+		if tag, ok := field.Tag.Lookup("msgpack"); ok {
+			if tag == "-" {
+				continue
+			}
+			if tag != "" {
+				name = tag
+			}
+		}
+		names = append(names, name)
+		fields = append(fields, v.Field(i))
+	}
+	return
+}
+
+/*the arrays and maps nested deeper are refused, the reader recurses into each of them*/
+var __MsgPackMaxDepth int = 64
+
+var ErrMsgPackTooDeep error = errors.New("MsgPack Nesting Too Deep")
+
+type msgpackReader struct {
+	buf   []byte
+	pos   int
+	depth int
+}
+
+/** every enter is followed by a leave once the container is read */
+func (r *msgpackReader) enter() error {
+	r.depth++
+	if r.depth > __MsgPackMaxDepth {
+		return ErrMsgPackTooDeep
+	}
+	return nil
+}
+
+func (r *msgpackReader) leave() {
+	r.depth--
+}
+
+func (r *msgpackReader) take(n int) (buf []byte, err error) {
+	if n < 0 || r.pos+n > len(r.buf) {
+		err = errors.New("MsgPack Data Is Short")
+		return
+	}
+	buf = r.buf[r.pos : r.pos+n]
+	r.pos += n
+	return
+}
+
+func (r *msgpackReader) size(n int) (size int, err error) {
+	buf, err := r.take(n)
+	if err != nil {
+		return
+	}
+	switch n {
+	case 1:
+		size = int(buf[0])
+	case 2:
+		size = int(binary.BigEndian.Uint16(buf))
+	case 4:
+		size = int(binary.BigEndian.Uint32(buf))
+	}
+	return
+}
+
+/** read the next value as nil, bool, int64, uint64, float64, string, []byte, []interface{} or a map */
+func (r *msgpackReader) next() (value interface{}, err error) {
+	head, err := r.take(1)
+	if err != nil {
+		return
+	}
+	b := head[0]
+	switch {
+	case b <= 0x7f:
+		return int64(b), nil
+	case b >= 0xe0:
+		return int64(int8(b)), nil
+	case b&0xe0 == 0xa0:
+		return r.str(int(b & 0x1f))
+	case b&0xf0 == 0x90:
+		return r.array(int(b & 0x0f))
+	case b&0xf0 == 0x80:
+		return r.dict(int(b & 0x0f))
+	}
+	var buf []byte
+	var size int
+	switch b {
+	case 0xc0:
+		return nil, nil
+	case 0xc2:
+		return false, nil
+	case 0xc3:
+		return true, nil
+	case 0xc4, 0xc5, 0xc6:
+		size, err = r.size(1 << (b - 0xc4))
+		if err != nil {
+			return
+		}
+		buf, err = r.take(size)
+		return append([]byte{}, buf...), err
+	case 0xca:
+		buf, err = r.take(4)
+		if err != nil {
+			return
+		}
+		return float64(math.Float32frombits(binary.BigEndian.Uint32(buf))), nil
+	case 0xcb:
+		buf, err = r.take(8)
+		if err != nil {
+			return
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(buf)), nil
+	case 0xcc, 0xcd, 0xce, 0xcf:
+		n := 1 << (b - 0xcc)
+		buf, err = r.take(n)
+		if err != nil {
+			return
+		}
+		var u uint64
+		for _, c := range buf {
+			u = u<<8 | uint64(c)
+		}
+		return u, nil
+	case 0xd0:
+		buf, err = r.take(1)
+		if err != nil {
+			return
+		}
+		return int64(int8(buf[0])), nil
+	case 0xd1:
+		buf, err = r.take(2)
+		if err != nil {
+			return
+		}
+		return int64(int16(binary.BigEndian.Uint16(buf))), nil
+	case 0xd2:
+		buf, err = r.take(4)
+		if err != nil {
+			return
+		}
+		return int64(int32(binary.BigEndian.Uint32(buf))), nil
+	case 0xd3:
+		buf, err = r.take(8)
+		if err != nil {
+			return
+		}
+		return int64(binary.BigEndian.Uint64(buf)), nil
+	case 0xd9, 0xda, 0xdb:
+		size, err = r.size(1 << (b - 0xd9))
+		if err != nil {
+			return
+		}
+		return r.str(size)
+	case 0xdc, 0xdd:
+		size, err = r.size(2 << (b - 0xdc))
+		if err != nil {
+			return
+		}
+		return r.array(size)
+	case 0xde, 0xdf:
+		size, err = r.size(2 << (b - 0xde))
+		if err != nil {
+			return
+		}
+		return r.dict(size)
+	}
+	err = fmt.Errorf("MsgPack Format 0x%x Is Not Supported", b)
+	return
+}
+
+func (r *msgpackReader) str(size int) (value interface{}, err error) {
+	buf, err := r.take(size)
+	if err != nil {
+		return
+	}
+	return string(buf), nil
+}
+
+func (r *msgpackReader) array(size int) (value interface{}, err error) {
+	if size > len(r.buf)-r.pos {
+		err = errors.New("MsgPack Data Is Short")
+		return
+	}
+	err = r.enter()
+	defer r.leave()
+	if err != nil {
+		return
+	}
+	items := make([]interface{}, size)
+	for i := 0; i < size; i++ {
+		items[i], err = r.next()
+		if err != nil {
+			return
+		}
+	}
+	return items, nil
+}
+
+/** the maps keyed by strings only are map[string]interface{} */
+func (r *msgpackReader) dict(size int) (value interface{}, err error) {
+	if size > len(r.buf)-r.pos {
+		err = errors.New("MsgPack Data Is Short")
+		return
+	}
+	err = r.enter()
+	defer r.leave()
+	if err != nil {
+		return
+	}
+	keys := make([]interface{}, size)
+	values := make([]interface{}, size)
+	allstr := true
+	for i := 0; i < size; i++ {
+		keys[i], err = r.next()
+		if err != nil {
+			return
+		}
+		switch key := keys[i].(type) {
+		case string:
+		case []byte:
+			keys[i] = string(key)
+			allstr = false
+		case []interface{}, map[string]interface{}, map[interface{}]interface{}:
+			err = errors.New("MsgPack Map Key Should Not Be A Container")
+			return
+		default:
+			allstr = false
+		}
+		values[i], err = r.next()
+		if err != nil {
+			return
+		}
+	}
+	if allstr {
+		dict := make(map[string]interface{}, size)
+		for i, key := range keys {
+			dict[key.(string)] = values[i]
+		}
+		return dict, nil
+	}
+	dict := make(map[interface{}]interface{}, size)
+	for i, key := range keys {
+		dict[key] = values[i]
+	}
+	return dict, nil
+}
+
+func msgpackAssign(dst reflect.Value, src interface{}) (err error) {
+	if dst.Kind() == reflect.Interface && dst.NumMethod() == 0 {
+		if src == nil {
+			dst.Set(reflect.Zero(dst.Type()))
+		} else {
+			dst.Set(reflect.ValueOf(src))
+		}
+		return
+	}
+	if src == nil {
+		dst.Set(reflect.Zero(dst.Type()))
+		return
+	}
+	mismatch := fmt.Errorf("MsgPack Cannot Put %T Into %v", src, dst.Type())
+	switch dst.Kind() {
+	case reflect.Ptr:
+		elem := reflect.New(dst.Type().Elem())
+		err = msgpackAssign(elem.Elem(), src)
+		if err != nil {
+			return
+		}
+		dst.Set(elem)
+	case reflect.Bool:
+		b, ok := src.(bool)
+		if !ok {
+			return mismatch
+		}
+		dst.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		var i int64
+		switch valued := src.(type) {
+		case int64:
+			i = valued
+		case uint64:
+			if valued > math.MaxInt64 {
+				return mismatch
+			}
+			i = int64(valued)
+		case float64:
+			if valued != math.Trunc(valued) || valued > math.MaxInt64 || valued < math.MinInt64 {
+				return mismatch
+			}
+			i = int64(valued)
+		default:
+			return mismatch
+		}
+		if dst.OverflowInt(i) {
+			return mismatch
+		}
+		dst.SetInt(i)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		var u uint64
+		switch valued := src.(type) {
+		case int64:
+			if valued < 0 {
+				return mismatch
+			}
+			u = uint64(valued)
+		case uint64:
+			u = valued
+		case float64:
+			if valued != math.Trunc(valued) || valued < 0 || valued > math.MaxUint64 {
+				return mismatch
+			}
+			u = uint64(valued)
+		default:
+			return mismatch
+		}
+		if dst.OverflowUint(u) {
+			return mismatch
+		}
+		dst.SetUint(u)
+	case reflect.Float32, reflect.Float64:
+		switch valued := src.(type) {
+		case float64:
+			dst.SetFloat(valued)
+		case int64:
+			dst.SetFloat(float64(valued))
+		case uint64:
+			dst.SetFloat(float64(valued))
+		default:
+			return mismatch
+		}
+	case reflect.String:
+		switch valued := src.(type) {
+		case string:
+			dst.SetString(valued)
+		case []byte:
+			dst.SetString(string(valued))
+		default:
+			return mismatch
+		}
+	case reflect.Slice:
+		if dst.Type().Elem().Kind() == reflect.Uint8 {
+			switch valued := src.(type) {
+			case []byte:
+				dst.SetBytes(valued)
+				return
+			case string:
+				dst.SetBytes([]byte(valued))
+				return
+			}
+		}
+		items, ok := src.([]interface{})
+		if !ok {
+			return mismatch
+		}
+		slice := reflect.MakeSlice(dst.Type(), len(items), len(items))
+		for i, item := range items {
+			err = msgpackAssign(slice.Index(i), item)
+			if err != nil {
+				return
+			}
+		}
+		dst.Set(slice)
+	case reflect.Array:
+		items, ok := src.([]interface{})
+		if !ok || len(items) > dst.Len() {
+			return mismatch
+		}
+		for i, item := range items {
+			err = msgpackAssign(dst.Index(i), item)
+			if err != nil {
+				return
+			}
+		}
+	case reflect.Map:
+		dict := reflect.MakeMap(dst.Type())
+		assign := func(key interface{}, value interface{}) (err error) {
+			k := reflect.New(dst.Type().Key()).Elem()
+			err = msgpackAssign(k, key)
+			if err != nil {
+				return
+			}
+			v := reflect.New(dst.Type().Elem()).Elem()
+			err = msgpackAssign(v, value)
+			if err != nil {
+				return
+			}
+			dict.SetMapIndex(k, v)
+			return
+		}
+		switch valued := src.(type) {
+		case map[string]interface{}:
+			for key, value := range valued {
+				err = assign(key, value)
+				if err != nil {
+					return
+				}
+			}
+		case map[interface{}]interface{}:
+			for key, value := range valued {
+				err = assign(key, value)
+				if err != nil {
+					return
+				}
+			}
+		default:
+			return mismatch
+		}
+		dst.Set(dict)
+	case reflect.Struct:
+		dict, ok := src.(map[string]interface{})
+		if !ok {
+			return mismatch
+		}
+		names, fields := msgpackFields(dst)
+		for i, name := range names {
+			value, ok := dict[name]
+			if !ok {
+				continue
+			}
+			err = msgpackAssign(fields[i], value)
+			if err != nil {
+				return
+			}
+		}
+	default:
+		return mismatch
+	}
+	return
+}
@@ -11,11 +11,15 @@ import (
 	"path"
 	"runtime"
 	"strings"
+	"sync"
 	"time"
 	//"unsafe"
 )
 
 var __errors chan error
+
+/*ErrIn sends with the read lock held, so CloseError never closes the channel under it*/
+var __errorsMutex sync.RWMutex
 var __logAddr io.Writer
 var __verbs bool = false
 
@@ -37,12 +41,11 @@ func SetLogAddress(w io.Writer) {
 }
 func init() {
 	__errors = make(chan error, 100)
-	go func() {
-		for {
-			err := <-__errors
+	go func(errs chan error) {
+		for err := range errs {
 			ErrInfo(err)
 		}
-	}()
+	}(__errors)
 }
 
 func ErrIn(err error, info ...string) (ok bool) {
@@ -58,18 +61,28 @@ func ErrIn(err error, info ...string) (ok bool) {
 			}
 			callStr += fmt.Sprintf("[%v:%v]->", path.Base(file), line)
 		}
-		__errors <- ErrAppend(
+		err = ErrAppend(
 			ErrAppend(
 				errors.New(fmt.Sprintf("[at %s]", callStr[:len(callStr)-2])),
 				err.Error(),
 			),
 			info...,
 		)
+		__errorsMutex.RLock()
+		defer __errorsMutex.RUnlock()
+		//closed by the last looper, nobody reads them any more
+		if __errors == nil {
+			ErrInfo(err)
+			return
+		}
+		__errors <- err
 	}
 	return
 }
 
 func CloseError() {
+	__errorsMutex.Lock()
+	defer __errorsMutex.Unlock()
 	if __errors != nil {
 		close(__errors)
 		__errors = nil
@@ -0,0 +1,96 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+)
+
+/*the actions under it are kept for flitter itself*/
+const MA_User_Base MessageAction = 32
+
+var (
+	__actionMutex sync.RWMutex
+	__actionNames map[MessageAction]string = make(map[MessageAction]string)
+	__actionIDs   map[string]MessageAction = make(map[string]MessageAction)
+	__actionNext  MessageAction            = MA_User_Base
+)
+
+/** give the name the next free action, the same name always gets the same action in one process */
+func RegisterAction(name string) (action MessageAction, err error) {
+	__actionMutex.Lock()
+	defer __actionMutex.Unlock()
+	action, ok := __actionIDs[name]
+	if ok {
+		return
+	}
+	for ; ; __actionNext++ {
+		if _, used := __actionNames[__actionNext]; !used {
+			break
+		}
+		if __actionNext == 0xff {
+			err = errors.New("No Free Action For " + name)
+			return
+		}
+	}
+	action = __actionNext
+	return action, registerAction(name, action)
+}
+
+/** pin the name to the action, so nodes registering in different orders still agree */
+func RegisterActionAt(name string, action MessageAction) (err error) {
+	__actionMutex.Lock()
+	defer __actionMutex.Unlock()
+	return registerAction(name, action)
+}
+
+func MustRegisterAction(name string) MessageAction {
+	action, err := RegisterAction(name)
+	if err != nil {
+		panic(err)
+	}
+	return action
+}
+
+func registerAction(name string, action MessageAction) (err error) {
+	if name == "" {
+		err = errors.New("Empty Action Name")
+		return
+	}
+	if action < MA_User_Base {
+		err = fmt.Errorf("Action %d Of %s Is Kept For Flitter", action, name)
+		return
+	}
+	if old, ok := __actionIDs[name]; ok && old != action {
+		err = fmt.Errorf("Action %s Has Registered As %d", name, old)
+		return
+	}
+	if old, ok := __actionNames[action]; ok && old != name {
+		err = fmt.Errorf("Action %d Has Registered As %s", action, old)
+		return
+	}
+	__actionIDs[name] = action
+	__actionNames[action] = name
+	return
+}
+
+/** find the action by its name, both the flitter ones and the registered ones */
+func LookupAction(name string) (action MessageAction, ok bool) {
+	for action = MA_Undefine; action <= MA_User_Request; action++ {
+		if action.String() == name {
+			ok = true
+			return
+		}
+	}
+	__actionMutex.RLock()
+	defer __actionMutex.RUnlock()
+	action, ok = __actionIDs[name]
+	return
+}
+
+func registeredName(action MessageAction) (name string, ok bool) {
+	__actionMutex.RLock()
+	defer __actionMutex.RUnlock()
+	name, ok = __actionNames[action]
+	return
+}
@@ -0,0 +1,48 @@
+package core
+
+import (
+	utils "github.com/gargous/flitter/common"
+	"testing"
+)
+
+func Test_RegisterAction(t *testing.T) {
+	t.Log(utils.Norf("Start RegisterAction"))
+	move, err := RegisterAction("GA_Move")
+	if err != nil || move < MA_User_Base {
+		t.Fatal(utils.Errf("Register err:%v,%d", err, move))
+	}
+	again, err := RegisterAction("GA_Move")
+	if err != nil || again != move {
+		t.Fatal(utils.Errf("Register again err:%v,%d,%d", err, again, move))
+	}
+	err = RegisterActionAt("GA_Attack", move)
+	if err == nil {
+		t.Fatal(utils.Errf("Two names on one action"))
+	}
+	err = RegisterActionAt("GA_Attack", MA_Lock)
+	if err == nil {
+		t.Fatal(utils.Errf("Took a flitter action"))
+	}
+	if move.String() != "GA_Move" || !move.Valid() || move.Normalize() != move {
+		t.Fatal(utils.Errf("Registered action not known:%v", move))
+	}
+	found, ok := LookupAction("GA_Move")
+	if !ok || found != move {
+		t.Fatal(utils.Errf("Lookup failed:%v", found))
+	}
+	found, ok = LookupAction("MA_Heartbeat")
+	if !ok || found != MA_Heartbeat {
+		t.Fatal(utils.Errf("Lookup flitter action failed:%v", found))
+	}
+	info := NewMessageInfo()
+	info.SetAcion(move)
+	serializer := NewSerializer()
+	_, buf, _ := serializer.Encode(info)
+	ninfo := NewMessageInfo()
+	_, err = serializer.Decode(ninfo, buf)
+	action, _, _ := ninfo.Info()
+	if err != nil || action != move {
+		t.Fatal(utils.Errf("Registered action lost:%v,%v", err, ninfo))
+	}
+	t.Log(utils.Norf("End RegisterAction"))
+}
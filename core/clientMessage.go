@@ -2,7 +2,6 @@ package core
 
 import (
 	"github.com/gargous/flitter/common"
-	"strconv"
 )
 
 type DataInfo struct {
@@ -18,44 +17,46 @@ func NewDataInfo(key string) DataInfo {
 	}
 }
 
+/*the contents of a DataInfo, after the ones of the ClientInfo*/
+type dataPayload struct {
+	Key   string `flitter:"2"`
+	Value []byte `flitter:"3"`
+	Count int    `flitter:"4"`
+}
+
 func (d *DataInfo) Parse(msg Message) (ok bool) {
-	count, ok := msg.GetContent(4)
-	if !ok {
+	var payload dataPayload
+	err := NewSerializer().Unpack(msg, &payload)
+	if err != nil {
+		ok = false
 		return
 	}
-	key, _ := msg.GetContent(2)
-	value, _ := msg.GetContent(3)
-	d.Key = string(key)
-
-	if len(value) > 0 {
-		err := d.Value.Parse(value)
+	d.Key = payload.Key
+	if len(payload.Value) > 0 {
+		err = d.Value.Parse(payload.Value)
 		if err != nil {
 			ok = false
 			return
 		}
 	}
+	d.Count = payload.Count
 	ok = true
-	var err error
-	d.Count, err = strconv.Atoi(string(count[:]))
-	if err != nil {
-		ok = false
-	}
 	return
 }
 
 func (d DataInfo) AppendToMsg(msg Message) (err error) {
-	msg.AppendContent([]byte(d.Key))
-	if d.Value.Data == nil || len(d.Value.Data) <= 0 {
-		msg.AppendContent([]byte("nil"))
-	} else {
-		b, err := d.Value.Bytes()
+	payload := dataPayload{
+		Key:   d.Key,
+		Value: []byte("nil"),
+		Count: d.Count,
+	}
+	if d.Value.Data != nil && len(d.Value.Data) > 0 {
+		payload.Value, err = d.Value.Bytes()
 		if err != nil {
 			return err
 		}
-		msg.AppendContent(b)
 	}
-	msg.AppendContent([]byte(strconv.Itoa(d.Count)))
-	return
+	return NewSerializer().Pack(msg, payload)
 }
 
 func (d DataInfo) AssertCount(all func() (ok bool), none func() (ok bool), one func() (ok bool)) (ok bool) {
@@ -98,13 +99,20 @@ func NewClientInfo(name string, path NodePath) (cinfo ClientInfo) {
 	return
 }
 
+/*the contents of a ClientInfo, at the head of the message*/
+type clientPayload struct {
+	Name string   `flitter:"0"`
+	Path NodePath `flitter:"1"`
+}
+
 func (c *ClientInfo) Parse(msg Message) (ok bool) {
-	contents := msg.GetContents()
-	if len(contents) <= 2 {
+	var payload clientPayload
+	err := NewSerializer().Unpack(msg, &payload)
+	if err != nil {
 		ok = false
 		return
 	}
-	cinfo := NewClientInfo(string(contents[0]), NodePath(contents[1]))
+	cinfo := NewClientInfo(payload.Name, payload.Path)
 	c.path = cinfo.path
 	c.name = cinfo.name
 	ok = true
@@ -112,6 +120,8 @@ func (c *ClientInfo) Parse(msg Message) (ok bool) {
 }
 func (c *ClientInfo) AppendToMsg(msg Message) {
 	msg.ClearContent()
-	msg.AppendContent([]byte(c.GetName()))
-	msg.AppendContent([]byte(c.GetPath()))
+	NewSerializer().Pack(msg, clientPayload{
+		Name: c.GetName(),
+		Path: c.GetPath(),
+	})
 }
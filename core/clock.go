@@ -0,0 +1,106 @@
+package core
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+/*where the loopers take the time and their timers from, so the tests can move it by hand*/
+type Clock interface {
+	Now() time.Time
+	//f runs in its own goroutine on the real clock
+	AfterFunc(d time.Duration, f func()) ClockTimer
+}
+
+type ClockTimer interface {
+	//false if it already fired or was stopped
+	Stop() bool
+}
+
+/*the time package itself*/
+var RealClock Clock = realClock{}
+
+type realClock struct{}
+
+func (r realClock) Now() time.Time {
+	return time.Now()
+}
+func (r realClock) AfterFunc(d time.Duration, f func()) ClockTimer {
+	return time.AfterFunc(d, f)
+}
+
+/*a clock standing still until Advance, the timers due fire in the goroutine advancing it*/
+type FakeClock struct {
+	mutex  sync.Mutex
+	now    time.Time
+	timers []*fakeTimer
+}
+
+func NewFakeClock(start time.Time) *FakeClock {
+	return &FakeClock{now: start}
+}
+
+type fakeTimer struct {
+	clock *FakeClock
+	at    time.Time
+	f     func()
+}
+
+func (c *FakeClock) Now() time.Time {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.now
+}
+
+func (c *FakeClock) AfterFunc(d time.Duration, f func()) ClockTimer {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	t := &fakeTimer{clock: c, at: c.now.Add(d), f: f}
+	c.timers = append(c.timers, t)
+	return t
+}
+
+/** fire the timers due by now+d one after another, the ones they start included */
+func (c *FakeClock) Advance(d time.Duration) {
+	c.mutex.Lock()
+	target := c.now.Add(d)
+	c.mutex.Unlock()
+	for {
+		c.mutex.Lock()
+		sort.SliceStable(c.timers, func(i, j int) bool {
+			return c.timers[i].at.Before(c.timers[j].at)
+		})
+		if len(c.timers) <= 0 || c.timers[0].at.After(target) {
+			c.now = target
+			c.mutex.Unlock()
+			return
+		}
+		t := c.timers[0]
+		c.timers = c.timers[1:]
+		if t.at.After(c.now) {
+			c.now = t.at
+		}
+		c.mutex.Unlock()
+		t.f()
+	}
+}
+
+/** the timers not fired nor stopped yet */
+func (c *FakeClock) Pending() int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return len(c.timers)
+}
+
+func (t *fakeTimer) Stop() bool {
+	t.clock.mutex.Lock()
+	defer t.clock.mutex.Unlock()
+	for index, other := range t.clock.timers {
+		if other == t {
+			t.clock.timers = append(t.clock.timers[:index], t.clock.timers[index+1:]...)
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,100 @@
+package core
+
+import (
+	"context"
+	utils "github.com/gargous/flitter/common"
+	"testing"
+	"time"
+)
+
+func Test_FakeClock(t *testing.T) {
+	t.Log(utils.Norf("Start Fake Clock"))
+	start := time.Date(2017, 3, 10, 0, 0, 0, 0, time.UTC)
+	clock := NewFakeClock(start)
+	fired := []time.Duration{}
+	clock.AfterFunc(2*time.Second, func() {
+		fired = append(fired, clock.Now().Sub(start))
+		clock.AfterFunc(time.Second, func() {
+			fired = append(fired, clock.Now().Sub(start))
+		})
+	})
+	stopped := clock.AfterFunc(time.Second, func() {
+		fired = append(fired, 0)
+	})
+	if !stopped.Stop() || stopped.Stop() {
+		t.Fatal(utils.Errf("Stop twice"))
+	}
+	clock.Advance(time.Second)
+	if len(fired) != 0 {
+		t.Fatal(utils.Errf("Fired early:%v", fired))
+	}
+	clock.Advance(5 * time.Second)
+	if len(fired) != 2 || fired[0] != 2*time.Second || fired[1] != 3*time.Second {
+		t.Fatal(utils.Errf("Wrong fired:%v", fired))
+	}
+	if clock.Now().Sub(start) != 6*time.Second || clock.Pending() != 0 {
+		t.Fatal(utils.Errf("Wrong now:%v", clock.Now()))
+	}
+
+	looper := NewMessageLooper(10)
+	looper.SetClock(clock)
+	ticks := 0
+	looper.SetInterval(3000, func(now time.Time) error {
+		ticks++
+		return nil
+	})
+	clock.Advance(9 * time.Second)
+	if ticks != 3 {
+		t.Fatal(utils.Errf("Ticked %d times", ticks))
+	}
+	t.Log(utils.Norf("End Fake Clock"))
+}
+
+func Test_MessageLooper_FakeClock(t *testing.T) {
+	t.Log(utils.Norf("Start Msg Looper Fake Clock"))
+	//a day behind the real clock
+	clock := NewFakeClock(time.Now().Add(-24 * time.Hour))
+	looper := NewMessageLooper(10)
+	looper.SetClock(clock)
+	states := make(chan MessageState, 10)
+	looper.AddRetryHandler(RetryPolicy{Timeout: 5000, MaxAttempts: 2, Backoff: 1000}, MA_Heartbeat, func(msg Message) error {
+		_, state, _ := msg.GetInfo().Info()
+		if state == MS_Failed {
+			looper.Retry(msg)
+		}
+		states <- state
+		return nil
+	})
+	go looper.Loop()
+	msg := NewMessage(NewMessageInfo())
+	msg.GetInfo().SetAcion(MA_Heartbeat)
+	msg.GetInfo().SetState(MS_Probe)
+	looper.Push(msg)
+	for _, step := range []struct {
+		advance time.Duration
+		state   MessageState
+	}{{0, MS_Probe}, {5 * time.Second, MS_Failed}, {time.Second, MS_Probe}, {5 * time.Second, MS_Failed}} {
+		clock.Advance(step.advance)
+		if state := <-states; state != step.state {
+			t.Fatal(utils.Errf("%v after %v, want %v", state, step.advance, step.state))
+		}
+	}
+	if letters := looper.DeadLetters().List(); len(letters) != 1 || !letters[0].Time.Equal(clock.Now()) {
+		t.Fatal(utils.Errf("Not given up by the clock:%v", letters))
+	}
+	//the deadline told by the clock, long past on the real one
+	done := make(chan error, 1)
+	looper.AddContextHandler(0, MA_Lock, func(ctx context.Context, msg Message) error {
+		done <- ctx.Err()
+		return nil
+	})
+	msg = NewMessage(NewMessageInfo())
+	msg.GetInfo().SetAcion(MA_Lock)
+	msg.GetInfo().SetDeadline(clock.Now().Add(time.Hour))
+	looper.Push(msg)
+	if err := <-done; err != nil {
+		t.Fatal(utils.Errf("Done before the deadline:%v", err))
+	}
+	looper.Term()
+	t.Log(utils.Norf("End Msg Looper Fake Clock"))
+}
@@ -0,0 +1,91 @@
+package core
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"strconv"
+	"strings"
+)
+
+/*
+the header listing the contents deflated by the sender, like "0,3",
+the peers never compressing still read the frames carrying it
+*/
+const HK_Compress string = "z"
+
+/*the bytes a content may inflate to, so a small frame cannot take all the memory*/
+var __MaxInflatedSize int64 = 64 << 20
+
+var (
+	ErrInflatedTooLarge error = errors.New("Inflated Content Too Large")
+)
+
+/** deflate the contents above the threshold, the info is copied only when some content shrinks */
+func compressContents(info MessageInfo, contents [][]byte, threshold int) (MessageInfo, [][]byte, error) {
+	var (
+		indexes    []string
+		compressed [][]byte
+	)
+	for index, content := range contents {
+		if len(content) < threshold {
+			continue
+		}
+		buffer := bytes.NewBuffer(make([]byte, 0, len(content)/2))
+		writer, err := getFlateWriter(buffer)
+		if err != nil {
+			return info, contents, err
+		}
+		_, err = writer.Write(content)
+		if err == nil {
+			err = writer.Close()
+		}
+		putFlateWriter(writer)
+		if err != nil {
+			return info, contents, err
+		}
+		if buffer.Len() >= len(content) {
+			continue
+		}
+		if compressed == nil {
+			compressed = make([][]byte, len(contents))
+			copy(compressed, contents)
+		}
+		compressed[index] = buffer.Bytes()
+		indexes = append(indexes, strconv.Itoa(index))
+	}
+	if compressed == nil {
+		return info, contents, nil
+	}
+	info = info.Copy()
+	info.SetHeader(HK_Compress, strings.Join(indexes, ","))
+	return info, compressed, nil
+}
+
+/** inflate the contents listed by the header and drop it, ErrInflatedTooLarge past __MaxInflatedSize */
+func decompressContents(info MessageInfo, contents [][]byte) (err error) {
+	header, ok := info.GetHeader(HK_Compress)
+	if !ok {
+		return
+	}
+	for _, indexstr := range strings.Split(header, ",") {
+		index, err := strconv.Atoi(indexstr)
+		if err != nil || index < 0 || index >= len(contents) {
+			return errors.New("Invalid Compressed Content " + indexstr)
+		}
+		reader := getFlateReader(bytes.NewReader(contents[index]))
+		contents[index], err = ioutil.ReadAll(io.LimitReader(reader, __MaxInflatedSize+1))
+		reader.Close()
+		putFlateReader(reader)
+		if err != nil {
+			return err
+		}
+		if int64(len(contents[index])) > __MaxInflatedSize {
+			contents[index] = nil
+			return ErrInflatedTooLarge
+		}
+	}
+	info.DelHeader(HK_Compress)
+	return
+}
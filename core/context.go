@@ -0,0 +1,63 @@
+package core
+
+import (
+	"context"
+	"time"
+)
+
+/*a handler told to stop when the looper terms, the message expires or its time is up*/
+type ContextHandler func(ctx context.Context, msg Message) error
+
+type contextKey uint8
+
+const (
+	__ContextMessage contextKey = iota
+	__ContextTrace
+)
+
+/*
+the context of handling msg under parent,
+done at the deadline of msg or after timeout milliseconds, 0 never times out,
+the deadline is told by clock, and the time left to it runs out on the real one,
+the trace of msg travels with it
+*/
+func NewMessageContext(parent context.Context, msg Message, timeout time.Duration, clock Clock) (ctx context.Context, cancel context.CancelFunc) {
+	ctx = context.WithValue(parent, __ContextMessage, msg)
+	info := msg.GetInfo()
+	if trace, ok := info.GetHeader(HK_Trace); ok {
+		ctx = WithTrace(ctx, trace)
+	}
+	now := clock.Now()
+	deadline, ok := info.GetDeadline()
+	if timeout > 0 {
+		if timeline := now.Add(timeout * time.Millisecond); !ok || timeline.Before(deadline) {
+			deadline, ok = timeline, true
+		}
+	}
+	if ok {
+		return context.WithTimeout(ctx, deadline.Sub(now))
+	}
+	return context.WithCancel(ctx)
+}
+
+/** the message handled under ctx */
+func MessageOf(ctx context.Context) (msg Message, ok bool) {
+	msg, ok = ctx.Value(__ContextMessage).(Message)
+	return
+}
+
+func WithTrace(ctx context.Context, trace string) context.Context {
+	return context.WithValue(ctx, __ContextTrace, trace)
+}
+
+func TraceOf(ctx context.Context) (trace string, ok bool) {
+	trace, ok = ctx.Value(__ContextTrace).(string)
+	return
+}
+
+/** the messages sent while handling carry on the trace of ctx */
+func PassTrace(ctx context.Context, info MessageInfo) {
+	if trace, ok := TraceOf(ctx); ok {
+		info.SetHeader(HK_Trace, trace)
+	}
+}
@@ -0,0 +1,157 @@
+package core
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+/*the header of the last error a message met, set on the errors gathered and the probes timed out*/
+const HK_Error string = "err"
+
+const __DeadLetterCapacity int = 256
+
+var (
+	ErrProbeTimeout error = errors.New("Probe Timeout")
+	ErrNoDeadLetter error = errors.New("No Such Dead Letter")
+)
+
+/*a message given up, kept to be looked at and injected again or discarded*/
+type DeadLetter struct {
+	ID        uint64
+	Msg       Message
+	Reason    string
+	Attempts  int
+	LastError string
+	Time      time.Time
+}
+
+type DeadLetters interface {
+	Bury(msg Message, reason string)
+	List() []DeadLetter
+	Reinject(id uint64) error
+	Discard(id uint64) bool
+	Len() int
+}
+
+/** the oldest ones are discarded beyond capacity, the letters are timed by now, push takes the ones injected again */
+func NewDeadLetters(capacity int, now func() time.Time, push func(msg Message) error) DeadLetters {
+	if now == nil {
+		now = time.Now
+	}
+	return &deadLetters{
+		capacity: capacity,
+		now:      now,
+		push:     push,
+		letters:  make(map[uint64]DeadLetter),
+	}
+}
+
+type deadLetters struct {
+	mutex    sync.Mutex
+	capacity int
+	now      func() time.Time
+	push     func(msg Message) error
+	letters  map[uint64]DeadLetter
+	//the ids from the oldest on
+	order []uint64
+}
+
+/** keep a reference of msg until it is injected again or discarded */
+func (d *deadLetters) Bury(msg Message, reason string) {
+	info := msg.GetInfo()
+	lastError, _ := info.GetHeader(HK_Error)
+	letter := DeadLetter{
+		ID:        info.GetID(),
+		Msg:       msg.Retain(),
+		Reason:    reason,
+		Attempts:  msg.GetVisitTimes(),
+		LastError: lastError,
+		Time:      d.now(),
+	}
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	if old, ok := d.letters[letter.ID]; ok {
+		old.Msg.Release()
+		d.remove(letter.ID)
+	}
+	d.letters[letter.ID] = letter
+	d.order = append(d.order, letter.ID)
+	for d.capacity > 0 && len(d.order) > d.capacity {
+		oldest := d.letters[d.order[0]]
+		d.remove(oldest.ID)
+		oldest.Msg.Release()
+	}
+}
+
+/** with the mutex held */
+func (d *deadLetters) remove(id uint64) {
+	delete(d.letters, id)
+	for index, oid := range d.order {
+		if oid == id {
+			d.order = append(d.order[:index], d.order[index+1:]...)
+			break
+		}
+	}
+}
+
+/** from the oldest on */
+func (d *deadLetters) List() []DeadLetter {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	letters := make([]DeadLetter, 0, len(d.order))
+	for _, id := range d.order {
+		letters = append(letters, d.letters[id])
+	}
+	return letters
+}
+
+/** probe the message again with all its attempts back, it stays if the push fails */
+func (d *deadLetters) Reinject(id uint64) (err error) {
+	d.mutex.Lock()
+	letter, ok := d.letters[id]
+	if ok {
+		d.remove(id)
+	}
+	d.mutex.Unlock()
+	if !ok {
+		return ErrNoDeadLetter
+	}
+	info := letter.Msg.GetInfo()
+	info.DelHeader(HK_Error)
+	info.SetState(MS_Probe)
+	info.SetTime(d.now())
+	//the contents may be in pooled buffers freed with the letter
+	fresh := NewMessage(info)
+	for _, content := range letter.Msg.GetContents() {
+		fresh.AppendContent(append([]byte(nil), content...))
+	}
+	err = d.push(fresh)
+	if err != nil {
+		d.mutex.Lock()
+		d.letters[id] = letter
+		d.order = append(d.order, id)
+		d.mutex.Unlock()
+		return
+	}
+	letter.Msg.Release()
+	return
+}
+
+func (d *deadLetters) Discard(id uint64) bool {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	letter, ok := d.letters[id]
+	if !ok {
+		return false
+	}
+	d.remove(id)
+	letter.Msg.Release()
+	return true
+}
+
+func (d *deadLetters) Len() int {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	return len(d.order)
+}
@@ -0,0 +1,51 @@
+package core
+
+import (
+	utils "github.com/gargous/flitter/common"
+	"testing"
+)
+
+func Test_DeadLetters(t *testing.T) {
+	t.Log(utils.Norf("Start Dead Letters"))
+	pushed := []Message{}
+	letters := NewDeadLetters(2, nil, func(msg Message) error {
+		pushed = append(pushed, msg)
+		return nil
+	})
+	ids := []uint64{}
+	for _, content := range []string{"a", "b", "c"} {
+		msg := NewMessage(NewMessageInfo())
+		msg.GetInfo().SetAcion(MA_Lock)
+		msg.GetInfo().SetState(MS_Failed)
+		msg.GetInfo().SetHeader(HK_Error, ErrProbeTimeout.Error())
+		msg.AppendContent([]byte(content))
+		msg.Visit()
+		letters.Bury(msg, "Retry Exhausted")
+		ids = append(ids, msg.GetInfo().GetID())
+	}
+	list := letters.List()
+	if len(list) != 2 || list[0].ID != ids[1] || list[1].ID != ids[2] {
+		t.Fatal(utils.Errf("The oldest not discarded:%v", list))
+	}
+	if list[0].Attempts != 1 || list[0].LastError != ErrProbeTimeout.Error() || list[0].Reason != "Retry Exhausted" {
+		t.Fatal(utils.Errf("Wrong letter:%+v", list[0]))
+	}
+	if letters.Reinject(ids[0]) != ErrNoDeadLetter {
+		t.Fatal(utils.Errf("Reinjected a discarded one"))
+	}
+	if err := letters.Reinject(ids[1]); err != nil {
+		t.Fatal(err)
+	}
+	if len(pushed) != 1 || pushed[0].GetVisitTimes() != 0 {
+		t.Fatal(utils.Errf("Not reinjected:%v", pushed))
+	}
+	_, state, _ := pushed[0].GetInfo().Info()
+	content, _ := pushed[0].GetContent(0)
+	if _, ok := pushed[0].GetInfo().GetHeader(HK_Error); ok || state != MS_Probe || string(content) != "b" {
+		t.Fatal(utils.Errf("Wrong reinjected:%v", pushed[0]))
+	}
+	if !letters.Discard(ids[2]) || letters.Discard(ids[2]) || letters.Len() != 0 {
+		t.Fatal(utils.Errf("Not discarded"))
+	}
+	t.Log(utils.Norf("End Dead Letters"))
+}
@@ -3,7 +3,13 @@ package core
 import (
 	"errors"
 	"fmt"
+	common "github.com/gargous/flitter/common"
 	zmq "github.com/pebbe/zmq4"
+	"io"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
 )
 
 type Subscriber interface {
@@ -16,6 +22,11 @@ type Subscriber interface {
 	GetConnNodeInfo() []NodeInfo
 	Close()
 	Recv() (Message, error)
+	Mismatches() uint64
+	Rejects() uint64
+	SetMismatchReply(reply func(msg Message, source NodePath) error)
+	SetSecret(secret []byte)
+	SetRecorder(recorder Recorder)
 }
 
 func NewSubscriber() (Subscriber, error) {
@@ -36,6 +47,9 @@ type Publisher interface {
 	GetBindNodeInfo() NodeInfo
 	Close()
 	Send(msg Message) error
+	SetCompression(threshold int)
+	SetSecret(secret []byte)
+	SetRecorder(recorder Recorder)
 }
 
 func NewPublisher(info NodeInfo) (Publisher, error) {
@@ -51,6 +65,11 @@ type Sender interface {
 	Disconnect(all bool)
 	Close()
 	Send(msg Message) error
+	SendStream(msg Message, reader io.Reader) error
+	SetCompression(threshold int)
+	SetSecret(secret []byte)
+	SetStreamer(streamer Streamer)
+	SetRecorder(recorder Recorder)
 }
 
 func NewSender() (Sender, error) {
@@ -63,6 +82,12 @@ type Receiver interface {
 	GetBindNodeInfo() NodeInfo
 	Close()
 	Recv() (Message, error)
+	Mismatches() uint64
+	Rejects() uint64
+	SetMismatchReply(reply func(msg Message, source NodePath) error)
+	SetSecret(secret []byte)
+	SetStreamer(streamer Streamer)
+	SetRecorder(recorder Recorder)
 }
 
 func NewReceiver(info NodeInfo) (Receiver, error) {
@@ -81,7 +106,15 @@ type Deliverer interface {
 	Disconnect(all bool)
 	Close()
 	Send(msg Message) error
+	SendStream(msg Message, reader io.Reader) error
 	Recv() (Message, error)
+	Mismatches() uint64
+	Rejects() uint64
+	SetMismatchReply(reply func(msg Message, source NodePath) error)
+	SetCompression(threshold int)
+	SetSecret(secret []byte)
+	SetStreamer(streamer Streamer)
+	SetRecorder(recorder Recorder)
 }
 
 func NewDeliverer(info NodeInfo, t zmq.Type) (Deliverer, error) {
@@ -102,6 +135,14 @@ type deliverer struct {
 	nowconnodes []NodeInfo
 	oldconnodes []NodeInfo
 	socket      *zmq.Socket
+	mismatches  uint64
+	rejects     uint64
+	compress    int
+	secret      []byte
+	streamer    Streamer
+	recorder    Recorder
+	//sends to the node at the source, the receiving socket cannot answer it
+	mismatchReply func(msg Message, source NodePath) error
 }
 
 func (s *deliverer) SetSubscribe(filter string) error {
@@ -210,20 +251,90 @@ func (d *deliverer) Close() {
 	}
 }
 
+/** the contents from threshold bytes on are deflated when sent, 0 turns it off */
+func (d *deliverer) SetCompression(threshold int) {
+	d.compress = threshold
+}
+
+/** sign every message sent and drop the ones received without the right signature, nil turns it off */
+func (d *deliverer) SetSecret(secret []byte) {
+	d.secret = secret
+}
+
+/** the chunks and the acks received are taken by the streamer instead of returned by Recv */
+func (d *deliverer) SetStreamer(streamer Streamer) {
+	d.streamer = streamer
+}
+
+/** the acks of the stream come back through the Receiver holding the same streamer */
+func (d *deliverer) SendStream(msg Message, reader io.Reader) error {
+	if d.streamer == nil {
+		return errors.New("No Streamer")
+	}
+	return d.streamer.Send(d.Send, msg, reader)
+}
+
+/** every message sent and received is recorded as it is before compressed and signed, nil turns it off */
+func (d *deliverer) SetRecorder(recorder Recorder) {
+	d.recorder = recorder
+}
+
+func (d *deliverer) record(sent bool, peer string, msg Message) {
+	if d.recorder == nil {
+		return
+	}
+	err := d.recorder.Record(Record{
+		Time:  time.Now(),
+		Sent:  sent,
+		Local: d.bindnode.GetAddress(),
+		Peer:  peer,
+		Msg:   msg,
+	})
+	if err != nil {
+		common.ErrIn(err, "Record")
+	}
+}
+
 func (d *deliverer) Send(msg Message) (err error) {
-	_, buf, err := NewSerializer().Encode(msg.GetInfo())
+	if d.recorder != nil {
+		peers := make([]string, len(d.nowconnodes))
+		for index, node := range d.nowconnodes {
+			peers[index] = node.GetAddress()
+		}
+		d.record(true, strings.Join(peers, ","), msg)
+	}
+	info := msg.GetInfo()
+	contents := msg.GetContents()
+	if d.compress > 0 {
+		info, contents, err = compressContents(info, contents, d.compress)
+		if err != nil {
+			return err
+		}
+	}
+	if len(d.secret) > 0 {
+		info = info.Copy()
+		info.SetHeader(HK_Signature, __SignatureAlgorithm)
+	}
+	_, head, err := NewSerializer().EncodePooled(info)
 	if err != nil {
 		return err
 	}
-	if msg.GetContents() == nil || len(msg.GetContents()) == 0 {
+	defer head.Free()
+	buf := head.B
+	if len(d.secret) > 0 {
+		signed := make([][]byte, len(contents), len(contents)+1)
+		copy(signed, contents)
+		contents = append(signed, signFrames(d.secret, buf, contents))
+	}
+	if contents == nil || len(contents) == 0 {
 		_, err = d.socket.SendBytes(buf, 0)
 	} else {
 		_, err = d.socket.SendBytes(buf, zmq.SNDMORE)
 		if err != nil {
 			return
 		}
-		for index, content := range msg.GetContents() {
-			if index == len(msg.GetContents())-1 {
+		for index, content := range contents {
+			if index == len(contents)-1 {
 				_, err = d.socket.SendBytes(content, 0)
 			} else {
 				_, err = d.socket.SendBytes(content, zmq.SNDMORE)
@@ -236,22 +347,121 @@ func (d *deliverer) Send(msg Message) (err error) {
 	return
 }
 func (d *deliverer) Recv() (msg Message, err error) {
-	bufs, err := d.socket.RecvMessageBytes(0)
-	if err != nil {
-		return
+	for {
+		msg, err = d.recv()
+		if err != nil || d.streamer == nil || !d.streamer.Resolve(msg) {
+			return
+		}
+		//the chunks and the acks are done with once resolved
+		msg.Release()
 	}
+}
+
+/** the frames go to a pooled message, released when the caller releases the one returned */
+func (d *deliverer) recv() (msg Message, err error) {
+	received := newPooledMessage()
+	for more := true; more; {
+		var frame []byte
+		frame, err = d.socket.RecvBytes(0)
+		if err == nil {
+			received.frames = append(received.frames, frame)
+			more, err = d.socket.GetRcvmore()
+		}
+		if err != nil {
+			received.Release()
+			return
+		}
+	}
+	return d.decode(received)
+}
+
+/** the head and the contents from the frames of received, which is released on errors */
+func (d *deliverer) decode(received *message) (msg Message, err error) {
+	defer func() {
+		if err != nil {
+			received.Release()
+		}
+	}()
+	bufs := received.frames
 	if len(bufs) < 1 {
 		err = errors.New("No Info In This Message")
+		return
 	}
 	msgInfo := NewMessageInfo()
 	_, err = NewSerializer().Decode(msgInfo, bufs[0])
+	if verr, ok := err.(*VersionError); ok {
+		d.mismatch(verr, bufs)
+		return
+	}
 	if err != nil {
 		return
 	}
-	msg = NewMessage(msgInfo)
-	msg.SetContents(bufs[1:])
+	contents := bufs[1:]
+	if len(d.secret) > 0 {
+		contents, err = verifyFrames(d.secret, msgInfo, bufs)
+		if err != nil {
+			d.reject(msgInfo, err)
+			return
+		}
+	}
+	err = decompressContents(msgInfo, contents)
+	if err != nil {
+		return
+	}
+	received.info = msgInfo
+	received.contents = contents
+	msg = received
+	if d.recorder != nil {
+		source, _ := msgInfo.GetHeader(HK_Source)
+		d.record(false, source, msg)
+	}
 	return
 }
+
+func (d *deliverer) reject(info MessageInfo, err error) {
+	atomic.AddUint64(&d.rejects, 1)
+	source, _ := info.GetHeader(HK_Source)
+	common.Logf(common.Warningf, "Drop %v from %s at %v", err, source, d.bindnode)
+}
+
+func (d *deliverer) Rejects() uint64 {
+	return atomic.LoadUint64(&d.rejects)
+}
+
+/** the heads of another version are answered with the version we speak */
+func (d *deliverer) SetMismatchReply(reply func(msg Message, source NodePath) error) {
+	d.mismatchReply = reply
+}
+
+/*
+count the mismatch and tell the peer which version we speak, if its source is known,
+the replies to a mismatch are not answered, nor the heads not signed with the secret if any
+*/
+func (d *deliverer) mismatch(verr *VersionError, bufs [][]byte) {
+	atomic.AddUint64(&d.mismatches, 1)
+	common.Logf(common.Warningf, "%v from peer %q at %v", verr, verr.Source, d.bindnode)
+	if d.mismatchReply == nil || verr.Source == "" || verr.State == MS_Error {
+		return
+	}
+	if len(d.secret) > 0 && !signedFrames(d.secret, bufs) {
+		common.Logf(common.Warningf, "Not Reply Version Mismatch %v from %q", ErrTampered, verr.Source)
+		return
+	}
+	info := NewMessageInfo()
+	info.SetState(MS_Error)
+	msg := NewMessage(info)
+	msg.AppendContent([]byte(verr.Error()))
+	msg.AppendContent([]byte(strconv.Itoa(int(ProtocolVersion))))
+	err := d.mismatchReply(msg, NodePath(verr.Source))
+	if err != nil {
+		common.ErrIn(err, "Reply Version Mismatch")
+	}
+}
+
+func (d *deliverer) Mismatches() uint64 {
+	return atomic.LoadUint64(&d.mismatches)
+}
+
 func (d deliverer) String() string {
 	nowcstr := ""
 	oldcstr := ""
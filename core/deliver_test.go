@@ -1,6 +1,7 @@
 package core
 
 import (
+	"bytes"
 	utils "github.com/gargous/flitter/utils"
 	"testing"
 )
@@ -50,3 +51,182 @@ func Test_Receiver(t *testing.T) {
 	}
 	t.Log(utils.Norf("End Receiver"))
 }
+
+func Test_Compress(t *testing.T) {
+	t.Log(utils.Norf("Start Compress"))
+	big := bytes.Repeat([]byte("flitter "), 512)
+	small := []byte("Hello")
+	info := NewMessageInfo()
+	info, contents, err := compressContents(info, [][]byte{small, big}, 256)
+	if err != nil {
+		t.Fatal(utils.Errf("compress err:%v", err))
+	}
+	header, ok := info.GetHeader(HK_Compress)
+	if !ok || header != "1" || len(contents[1]) >= len(big) || !bytes.Equal(contents[0], small) {
+		t.Fatal(utils.Errf("compress wrong:%v,%d", info, len(contents[1])))
+	}
+	err = decompressContents(info, contents)
+	if err != nil {
+		t.Fatal(utils.Errf("decompress err:%v", err))
+	}
+	if _, ok = info.GetHeader(HK_Compress); ok || !bytes.Equal(contents[1], big) {
+		t.Fatal(utils.Errf("decompress wrong:%v", info))
+	}
+	info, contents, err = compressContents(NewMessageInfo(), [][]byte{big}, 256)
+	if err != nil {
+		t.Fatal(utils.Errf("compress err:%v", err))
+	}
+	limit := __MaxInflatedSize
+	__MaxInflatedSize = int64(len(big) - 1)
+	defer func() { __MaxInflatedSize = limit }()
+	if err = decompressContents(info, contents); err != ErrInflatedTooLarge {
+		t.Fatal(utils.Errf("inflated past the limit:%v", err))
+	}
+	t.Log(utils.Norf("End Compress"))
+}
+
+func Test_Sign(t *testing.T) {
+	t.Log(utils.Norf("Start Sign"))
+	secret := []byte("flitter")
+	info := NewMessageInfo()
+	info.SetHeader(HK_Signature, __SignatureAlgorithm)
+	_, head, _ := NewSerializer().Encode(info)
+	contents := [][]byte{[]byte("Hello"), []byte("World")}
+	bufs := append([][]byte{head}, contents...)
+	bufs = append(bufs, signFrames(secret, head, contents))
+	got, err := verifyFrames(secret, info.Copy(), bufs)
+	if err != nil || len(got) != 2 {
+		t.Fatal(utils.Errf("verify err:%v,%v", err, got))
+	}
+	bufs[2] = []byte("Wrold")
+	_, err = verifyFrames(secret, info.Copy(), bufs)
+	if err != ErrTampered {
+		t.Fatal(utils.Errf("should be tampered but:%v", err))
+	}
+	_, err = verifyFrames(secret, NewMessageInfo(), bufs)
+	if err != ErrUnsigned {
+		t.Fatal(utils.Errf("should be unsigned but:%v", err))
+	}
+	t.Log(utils.Norf("End Sign"))
+}
+
+func Test_Mismatch(t *testing.T) {
+	t.Log(utils.Norf("Start Mismatch"))
+	d := &deliverer{}
+	var replied NodePath
+	d.SetMismatchReply(func(msg Message, source NodePath) error {
+		replied = source
+		if _, state, _ := msg.GetInfo().Info(); state != MS_Error {
+			t.Error(utils.Errf("Not an error reply:%v", msg))
+		}
+		return nil
+	})
+	info := NewMessageInfo()
+	info.SetHeader(HK_Source, "peer@127.0.0.1:8000")
+	_, head, err := NewSerializer().Encode(info)
+	if err != nil {
+		t.Fatal(err)
+	}
+	head[1] = ProtocolVersion + 1
+	received := newPooledMessage()
+	received.frames = append(received.frames, head)
+	_, err = d.decode(received)
+	if _, ok := err.(*VersionError); !ok || d.Mismatches() != 1 || replied != "peer@127.0.0.1:8000" {
+		t.Fatal(utils.Errf("Not replied to the peer:%v,%q", err, replied))
+	}
+	mismatched := func(state MessageState, signed bool) {
+		replied = ""
+		info := NewMessageInfo()
+		info.SetState(state)
+		info.SetHeader(HK_Source, "peer@127.0.0.1:8000")
+		_, head, err := NewSerializer().Encode(info)
+		if err != nil {
+			t.Fatal(err)
+		}
+		head[1] = ProtocolVersion + 1
+		received := newPooledMessage()
+		received.frames = append(received.frames, head)
+		if signed {
+			received.frames = append(received.frames, signFrames(d.secret, head, nil))
+		}
+		d.decode(received)
+	}
+	//the reply of the peer to our reply
+	mismatched(MS_Error, false)
+	if replied != "" {
+		t.Fatal(utils.Errf("Replied to a mismatch reply"))
+	}
+	d.SetSecret([]byte("flitter"))
+	mismatched(MS_Probe, false)
+	if replied != "" {
+		t.Fatal(utils.Errf("Replied to an unsigned head"))
+	}
+	mismatched(MS_Probe, true)
+	if replied != "peer@127.0.0.1:8000" {
+		t.Fatal(utils.Errf("Not replied to a signed head"))
+	}
+	t.Log(utils.Norf("End Mismatch"))
+}
+
+func Benchmark_Deliverer(b *testing.B) {
+	info := NewNodeInfo()
+	info.Parse("*:8020")
+	receiver, err := NewReceiver(info)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer receiver.Close()
+	err = receiver.Bind()
+	if err != nil {
+		b.Fatal(err)
+	}
+	sender, err := NewSender()
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer sender.Close()
+	info.Parse("127.0.0.1:8020")
+	sender.AddNodeInfo(info)
+	err = sender.Connect()
+	if err != nil {
+		b.Fatal(err)
+	}
+	msg := NewMessage(NewMessageInfo())
+	msg.GetInfo().SetAcion(MA_Update)
+	msg.AppendContent(bytes.Repeat([]byte("pos"), 32))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		err = sender.Send(msg)
+		if err != nil {
+			b.Fatal(err)
+		}
+		got, err := receiver.Recv()
+		if err != nil {
+			b.Fatal(err)
+		}
+		got.Release()
+	}
+}
+
+func Benchmark_Deliverer_Decode(b *testing.B) {
+	d := &deliverer{}
+	info := NewMessageInfo()
+	info.SetAcion(MA_Update)
+	_, head, err := NewSerializer().Encode(info)
+	if err != nil {
+		b.Fatal(err)
+	}
+	content := bytes.Repeat([]byte("pos"), 32)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		received := newPooledMessage()
+		received.frames = append(received.frames, head, content)
+		got, err := d.decode(received)
+		if err != nil {
+			b.Fatal(err)
+		}
+		got.Release()
+	}
+}
@@ -0,0 +1,215 @@
+package core
+
+import (
+	"encoding/json"
+	"errors"
+	"strconv"
+	"time"
+)
+
+/** by name, the actions without one by number */
+func (m MessageAction) MarshalJSON() ([]byte, error) {
+	name := m.String()
+	if name == "" {
+		return []byte(strconv.Itoa(int(m))), nil
+	}
+	return json.Marshal(name)
+}
+
+func (m *MessageAction) UnmarshalJSON(buf []byte) (err error) {
+	var name string
+	if json.Unmarshal(buf, &name) != nil {
+		var number uint8
+		err = json.Unmarshal(buf, &number)
+		*m = MessageAction(number)
+		return
+	}
+	action, ok := LookupAction(name)
+	if !ok {
+		return errors.New("No Action " + name)
+	}
+	*m = action
+	return
+}
+
+func (m MessageState) MarshalJSON() ([]byte, error) {
+	name := m.String()
+	if name == "" {
+		return []byte(strconv.Itoa(int(m))), nil
+	}
+	return json.Marshal(name)
+}
+
+func (m *MessageState) UnmarshalJSON(buf []byte) (err error) {
+	var name string
+	if json.Unmarshal(buf, &name) != nil {
+		var number uint8
+		err = json.Unmarshal(buf, &number)
+		*m = MessageState(number)
+		return
+	}
+	for state := MS_Probe; state <= MS_Local; state++ {
+		if state.String() == name {
+			*m = state
+			return
+		}
+	}
+	return errors.New("No State " + name)
+}
+
+/*the ids are strings so the tools reading numbers as float64 keep them*/
+type messageInfoJSON struct {
+	Action  MessageAction     `json:"action"`
+	State   MessageState      `json:"state"`
+	Time    time.Time         `json:"time"`
+	ID      uint64            `json:"id,string"`
+	ReplyTo uint64            `json:"replyto,string,omitempty"`
+	Headers map[string]string `json:"headers,omitempty"`
+}
+
+func (m *messageInfo) MarshalJSON() ([]byte, error) {
+	return json.Marshal(messageInfoJSON{
+		Action:  m.action,
+		State:   m.state,
+		Time:    time.Unix(0, m.sendtime),
+		ID:      m.id,
+		ReplyTo: m.replyto,
+		Headers: m.headers,
+	})
+}
+
+/** the id is kept when the json has none */
+func (m *messageInfo) UnmarshalJSON(buf []byte) (err error) {
+	data := messageInfoJSON{
+		ID: m.id,
+	}
+	err = json.Unmarshal(buf, &data)
+	if err != nil {
+		return
+	}
+	m.action = data.Action
+	m.state = data.State
+	m.sendtime = 0
+	if !data.Time.IsZero() {
+		m.sendtime = data.Time.UnixNano()
+	}
+	m.id = data.ID
+	m.replyto = data.ReplyTo
+	m.headers = nil
+	for key, value := range data.Headers {
+		m.SetHeader(key, value)
+	}
+	return
+}
+
+/*the frames are base64 as every []byte in json*/
+type messageJSON struct {
+	Info     json.RawMessage `json:"info"`
+	Visit    int             `json:"visit,omitempty"`
+	Contents [][]byte        `json:"contents"`
+}
+
+func (m *message) MarshalJSON() ([]byte, error) {
+	info, err := json.Marshal(m.info)
+	if err != nil {
+		return nil, err
+	}
+	contents := m.contents
+	if contents == nil {
+		contents = make([][]byte, 0)
+	}
+	return json.Marshal(messageJSON{
+		Info:     info,
+		Visit:    m.visit,
+		Contents: contents,
+	})
+}
+
+func (m *message) UnmarshalJSON(buf []byte) (err error) {
+	var data messageJSON
+	err = json.Unmarshal(buf, &data)
+	if err != nil {
+		return
+	}
+	if m.info == nil {
+		m.info = NewMessageInfo()
+	}
+	if len(data.Info) > 0 {
+		err = json.Unmarshal(data.Info, m.info)
+		if err != nil {
+			return
+		}
+	}
+	m.visit = data.Visit
+	m.contents = data.Contents
+	if m.contents == nil {
+		m.contents = make([][]byte, 0)
+	}
+	return
+}
+
+/** read a message written by MarshalJSON */
+func ParseMessageJSON(buf []byte) (msg Message, err error) {
+	msg = NewMessage(NewMessageInfo())
+	err = json.Unmarshal(buf, msg)
+	if err != nil {
+		msg = nil
+	}
+	return
+}
+
+type clientInfoJSON struct {
+	Name string   `json:"name"`
+	Path NodePath `json:"path"`
+}
+
+func (c ClientInfo) MarshalJSON() ([]byte, error) {
+	return json.Marshal(clientInfoJSON{
+		Name: c.GetName(),
+		Path: c.GetPath(),
+	})
+}
+
+func (c *ClientInfo) UnmarshalJSON(buf []byte) (err error) {
+	var data clientInfoJSON
+	err = json.Unmarshal(buf, &data)
+	if err != nil {
+		return
+	}
+	*c = NewClientInfo(data.Name, data.Path)
+	return
+}
+
+type dataInfoJSON struct {
+	Key   string `json:"key"`
+	Value struct {
+		Data        []byte `json:"data"`
+		Version     uint32 `json:"version"`
+		ContentType string `json:"contentType,omitempty"`
+	} `json:"value"`
+	Count int `json:"count"`
+}
+
+func (d DataInfo) MarshalJSON() ([]byte, error) {
+	var data dataInfoJSON
+	data.Key = d.Key
+	data.Value.Data = d.Value.Data
+	data.Value.Version = d.Value.Version
+	data.Value.ContentType = d.Value.ContentType
+	data.Count = d.Count
+	return json.Marshal(data)
+}
+
+func (d *DataInfo) UnmarshalJSON(buf []byte) (err error) {
+	var data dataInfoJSON
+	err = json.Unmarshal(buf, &data)
+	if err != nil {
+		return
+	}
+	d.Key = data.Key
+	d.Value.Data = data.Value.Data
+	d.Value.Version = data.Value.Version
+	d.Value.ContentType = data.Value.ContentType
+	d.Count = data.Count
+	return
+}
@@ -1,144 +1,651 @@
 package core
 
 import (
+	"context"
+	"errors"
 	utils "github.com/gargous/flitter/common"
+	"hash/fnv"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
+var (
+	ErrDrainTimeout error = errors.New("Drain Timeout")
+)
+
+/*the loopers looping in the process, the last one to exit closes the errors*/
+var __loopers int32
+
+/*the timer of one message, holding a reference of it until fired or stopped*/
 type TimeTricker struct {
-	timer    *time.Timer
-	duration time.Duration
+	timer ClockTimer
+	msg   Message
 }
 
+/** the message waited for is released unless the timer already fired */
 func (t *TimeTricker) Stop() {
-	if t.timer != nil {
-		t.timer.Stop()
+	if t.timer != nil && t.timer.Stop() && t.msg != nil {
+		t.msg.Release()
 	}
 	t.timer = nil
+	t.msg = nil
 }
 
 /*hand over the messages*/
 type MessageLooper interface {
 	GetHandler() map[MessageAction]MessageHandler
 	AddHandler(maxHandleTime time.Duration, action MessageAction, handler MessageHandler)
+	AddRetryHandler(policy RetryPolicy, action MessageAction, handler MessageHandler)
+	AddContextHandler(maxHandleTime time.Duration, action MessageAction, handler ContextHandler)
+	Retry(msg Message) bool
 	RemoveHandler(action MessageAction)
-	SetInterval(timestamp time.Duration, handler func(t time.Time) error)
+	SetInterval(timestamp time.Duration, handler func(t time.Time) error) Timer
+	After(delay time.Duration, handler func(t time.Time) error) Timer
+	Every(interval time.Duration, jitter float64, handler func(t time.Time) error) Timer
+	Cron(spec string, handler func(t time.Time) error) (Timer, error)
+	SetExpiry(handler MessageHandler)
+	Use(middlewares ...Middleware)
+	SetWorkers(count int, keyOf func(msg Message) string)
+	SetSchedule(schedule Schedule, weights ...int)
 	Loop()
 	Push(msg Message)
+	TryPush(msg Message) error
+	SetBackpressure(backpressure Backpressure, actions ...MessageAction)
+	Overflows() OverflowStats
+	DeadLetters() DeadLetters
+	SetClock(clock Clock)
+	Clock() Clock
+	Stats() LooperStats
 	Term()
+	Drain(timeout time.Duration) error
 }
 
+/** every priority gets a lane of bufferSize, strict by default */
 func NewMessageLooper(bufferSize int) MessageLooper {
-	return &messageLooper{
-		msgs:          make(chan Message, bufferSize),
+	m := &messageLooper{
+		ready:         make(chan bool, bufferSize*int(__PriorityCount)),
 		handlers:      make(map[MessageAction]MessageHandler),
-		handleTricker: make(map[MessageAction]TimeTricker),
+		wrapped:       make(map[MessageAction]MessageHandler),
+		policies:      make(map[MessageAction]RetryPolicy),
+		probeTricker:  make(map[uint64]*TimeTricker),
+		retryTricker:  make(map[uint64]*TimeTricker),
+		schedule:      SCH_Strict,
+		done:          make(chan bool),
+		timers:        make(map[*looperTimer]bool),
+		backpressures: make(map[MessageAction]Backpressure),
+		clock:         RealClock,
 	}
+	m.ctx, m.cancel = context.WithCancel(context.Background())
+	m.deadLetters = NewDeadLetters(__DeadLetterCapacity, func() time.Time {
+		return m.clock.Now()
+	}, m.TryPush)
+	for index := range m.lanes {
+		m.lanes[index] = make(chan Message, bufferSize)
+	}
+	return m
 }
 
 /*hand over the messages*/
 type messageLooper struct {
-	msgs          chan Message
-	handlers      map[MessageAction]MessageHandler
-	handleTricker map[MessageAction]TimeTricker
+	//one token for every message in the lanes
+	ready    chan bool
+	lanes    [__PriorityCount]chan Message
+	schedule Schedule
+	weights  [__PriorityCount]int
+	credits  [__PriorityCount]int
+	turn     int
+	//of the handlers, the middlewares and the policies, which may change while looping
+	handlerMutex sync.RWMutex
+	handlers     map[MessageAction]MessageHandler
+	//the handlers inside the middlewares
+	wrapped      map[MessageAction]MessageHandler
+	middlewares  []Middleware
+	policies     map[MessageAction]RetryPolicy
+	trickerMutex sync.Mutex
+	//by the id of the probes waiting to succeed
+	probeTricker map[uint64]*TimeTricker
+	//by the id of the failed ones waiting to probe again
+	retryTricker map[uint64]*TimeTricker
+	expiry       MessageHandler
+	//the handlers run in them if any, instead of a goroutine each
+	workers    []*worker
+	keyOf      func(msg Message) string
+	nextWorker int
+	//done when the looper terms
+	ctx    context.Context
+	cancel context.CancelFunc
+	//no push is taken once closed
+	mutex    sync.RWMutex
+	closed   bool
+	pushing  sync.WaitGroup
+	done     chan bool
+	inflight sync.WaitGroup
+	//the timers not stopped yet
+	timerMutex sync.Mutex
+	timers     map[*looperTimer]bool
+	//of the full lanes, by action or for any other
+	backpressure  Backpressure
+	backpressures map[MessageAction]Backpressure
+	overflows     overflowCounters
+	deadLetters   DeadLetters
+	clock         Clock
+	stats         looperStats
+	//in __loopers, once however many times Loop is called
+	counted sync.Once
+}
+
+type handling struct {
+	handler MessageHandler
+	msg     Message
+}
+
+/*
+the handlings of a worker goroutine in order, at most size of them queued,
+the loop waits for room, so the lanes fill up and the backpressure takes the pushes,
+the handlers pushing back to their looper had better give up after a while
+*/
+type worker struct {
+	mutex   sync.Mutex
+	size    int
+	pending []handling
+	wake    chan bool
+	room    chan bool
+	closed  bool
+}
+
+func newWorker(size int) *worker {
+	if size < 1 {
+		size = 1
+	}
+	return &worker{
+		size: size,
+		wake: make(chan bool, 1),
+		room: make(chan bool, 1),
+	}
+}
+
+/** wait for room in the queue, false if stop is done before */
+func (w *worker) put(h handling, stop <-chan struct{}) bool {
+	for {
+		w.mutex.Lock()
+		if len(w.pending) < w.size {
+			w.pending = append(w.pending, h)
+			w.mutex.Unlock()
+			signal(w.wake)
+			return true
+		}
+		w.mutex.Unlock()
+		select {
+		case <-w.room:
+		case <-stop:
+			return false
+		}
+	}
+}
+
+func (w *worker) queued() int {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	return len(w.pending)
+}
+
+func signal(c chan bool) {
+	select {
+	case c <- true:
+	default:
+	}
+}
+
+/** false once closed and every handling taken */
+func (w *worker) take() (h handling, ok bool) {
+	for {
+		w.mutex.Lock()
+		if len(w.pending) > 0 {
+			h = w.pending[0]
+			w.pending[0] = handling{}
+			w.pending = w.pending[1:]
+			w.mutex.Unlock()
+			signal(w.room)
+			return h, true
+		}
+		closed := w.closed
+		w.mutex.Unlock()
+		if closed {
+			return
+		}
+		<-w.wake
+	}
+}
+
+/** the handlings put before are still taken */
+func (w *worker) close() {
+	w.mutex.Lock()
+	w.closed = true
+	w.mutex.Unlock()
+	signal(w.wake)
 }
 
+/** the reference of the queue is released when the handler returns */
 func (m *messageLooper) goHandle(handler MessageHandler, msg Message) {
-	go func() {
-		m.gatherError(handler, msg)
-	}()
+	m.inflight.Add(1)
+	if len(m.workers) <= 0 {
+		go func() {
+			defer m.inflight.Done()
+			m.handle(handler, msg)
+		}()
+		return
+	}
+	if !m.workers[m.workerOf(msg)].put(handling{handler: handler, msg: msg}, m.ctx.Done()) {
+		//the looper terms
+		m.inflight.Done()
+		msg.Release()
+	}
 }
-func (m *messageLooper) gatherError(handler MessageHandler, msg Message) {
-	err := handler(msg)
+
+/*
+handle the messages in count goroutines, before Loop,
+the ones with the same key from keyOf in order and the ones without a key by turns,
+every worker queues as many as a lane
+*/
+func (m *messageLooper) SetWorkers(count int, keyOf func(msg Message) string) {
+	m.workers = make([]*worker, count)
+	m.keyOf = keyOf
+	for index := range m.workers {
+		w := newWorker(cap(m.lanes[0]))
+		m.workers[index] = w
+		go func() {
+			for {
+				h, ok := w.take()
+				if !ok {
+					return
+				}
+				m.handle(h.handler, h.msg)
+				m.inflight.Done()
+			}
+		}()
+	}
+}
+
+func (m *messageLooper) workerOf(msg Message) int {
+	if m.keyOf != nil {
+		if key := m.keyOf(msg); key != "" {
+			hash := fnv.New32a()
+			hash.Write([]byte(key))
+			return int(hash.Sum32() % uint32(len(m.workers)))
+		}
+	}
+	m.nextWorker = (m.nextWorker + 1) % len(m.workers)
+	return m.nextWorker
+}
+
+/** count and time the handling by the action and state msg came in */
+func (m *messageLooper) handle(handler MessageHandler, msg Message) {
+	action, state, _ := msg.GetInfo().Info()
+	atomic.AddInt64(&m.stats.inflight, 1)
+	start := m.clock.Now()
+	err := m.gatherError(handler, msg)
+	m.stats.handled(action, state, m.clock.Now().Sub(start), err)
+	atomic.AddInt64(&m.stats.inflight, -1)
+	msg.Release()
+}
+
+/** the error goes back as a copy of msg, which the handler may have pushed already */
+func (m *messageLooper) gatherError(handler MessageHandler, msg Message) (err error) {
+	err = protect(handler, msg)
 	if err != nil {
+		var failed Message
 		if msg == nil {
-			msg = NewMessage(NewMessageInfo())
+			failed = NewMessage(NewMessageInfo())
+		} else {
+			failed = msg.Copy()
 		}
-		msg.GetInfo().SetState(MS_Error)
-		msg.AppendContent([]byte(err.Error()))
-		m.Push(msg)
+		failed.GetInfo().SetState(MS_Error)
+		failed.GetInfo().SetHeader(HK_Error, err.Error())
+		failed.AppendContent([]byte(err.Error()))
+		m.pushOwn(failed)
+		failed.Release()
 	}
+	return
 }
+
+/** the messages pushed after Term or Drain, or not taken by the backpressure are dropped */
 func (m *messageLooper) Push(msg Message) {
-	if m.msgs != nil {
-		_, state, _ := msg.GetInfo().Info()
-		if state == MS_Failed {
-			msg.Visit()
+	err := m.TryPush(msg)
+	if err != nil {
+		utils.Logf(utils.Warningf, "Drop %v %v", err, msg.GetInfo())
+	}
+}
+
+/** ErrLooperClosed after Term or Drain, ErrQueueFull when the backpressure does not take msg */
+func (m *messageLooper) TryPush(msg Message) (err error) {
+	return m.push(msg, m.enqueue)
+}
+
+func (m *messageLooper) push(msg Message, enqueue func(msg Message) error) (err error) {
+	m.mutex.RLock()
+	if m.closed {
+		m.mutex.RUnlock()
+		return ErrLooperClosed
+	}
+	m.pushing.Add(1)
+	m.mutex.RUnlock()
+	defer m.pushing.Done()
+	_, state, _ := msg.GetInfo().Info()
+	if state == MS_Failed {
+		msg.Visit()
+	}
+	err = enqueue(msg.Retain())
+	if err == errQueueLater {
+		return nil
+	}
+	if err != nil {
+		msg.Release()
+		return
+	}
+	m.ready <- true
+	return
+}
+
+/*the msg is queued in the background once there is room*/
+var errQueueLater error = errors.New("Queue Later")
+
+/** the retries, the failed probes and the errors gathered wait for room whatever the backpressure, without holding the one pushing */
+func (m *messageLooper) pushOwn(msg Message) {
+	err := m.push(msg, func(msg Message) error {
+		lane := m.lanes[PriorityOf(msg)]
+		select {
+		case lane <- msg:
+			return nil
+		default:
+		}
+		m.pushing.Add(1)
+		go func() {
+			defer m.pushing.Done()
+			select {
+			case lane <- msg:
+				m.ready <- true
+			case <-m.done:
+				msg.Release()
+			}
+		}()
+		return errQueueLater
+	})
+	if err != nil {
+		utils.Logf(utils.Warningf, "Drop %v %v", err, msg.GetInfo())
+	}
+}
+
+/** the weights are given from the high lane on, the lanes without one get 1 */
+func (m *messageLooper) SetSchedule(schedule Schedule, weights ...int) {
+	m.schedule = schedule
+	for index := range m.weights {
+		m.weights[index] = 1
+		if index < len(weights) && weights[index] > 0 {
+			m.weights[index] = weights[index]
 		}
-		m.msgs <- msg
 	}
+	m.credits = m.weights
+	m.turn = 0
 }
+
+/** there is a message in some lane for every token taken from ready */
+func (m *messageLooper) next() Message {
+	if m.schedule == SCH_Weighted {
+		for round := 0; round < 2; round++ {
+			for index := range m.lanes {
+				lane := (m.turn + index) % len(m.lanes)
+				if m.credits[lane] <= 0 {
+					continue
+				}
+				select {
+				case msg := <-m.lanes[lane]:
+					m.credits[lane]--
+					m.turn = lane
+					return msg
+				default:
+				}
+			}
+			m.credits = m.weights
+			m.turn = 0
+		}
+	}
+	for _, lane := range m.lanes {
+		select {
+		case msg := <-lane:
+			return msg
+		default:
+		}
+	}
+	return nil
+}
+
+/** the probes not succeeded in maxHandleTime milliseconds come back as MS_Failed, 0 never */
 func (m *messageLooper) AddHandler(maxHandleTime time.Duration, action MessageAction, handler MessageHandler) {
+	m.AddRetryHandler(RetryPolicy{Timeout: maxHandleTime}, action, handler)
+}
+func (m *messageLooper) AddRetryHandler(policy RetryPolicy, action MessageAction, handler MessageHandler) {
+	m.handlerMutex.Lock()
+	defer m.handlerMutex.Unlock()
 	m.handlers[action] = handler
-	if maxHandleTime > 0 {
-		m.handleTricker[action] = TimeTricker{
-			timer:    nil,
-			duration: maxHandleTime * time.Millisecond,
+	m.wrapped[action] = m.wrap(handler)
+	m.policies[action] = policy
+}
+
+/** the context of the handler is done after maxHandleTime milliseconds, at the deadline of the message or when the looper terms */
+func (m *messageLooper) AddContextHandler(maxHandleTime time.Duration, action MessageAction, handler ContextHandler) {
+	m.AddHandler(maxHandleTime, action, func(msg Message) error {
+		ctx, cancel := NewMessageContext(m.ctx, msg, maxHandleTime, m.clock)
+		defer cancel()
+		return handler(ctx, msg)
+	})
+}
+
+/** the first middleware is the outermost, they wrap the handlers added before and after, the messages taken after it go through them */
+func (m *messageLooper) Use(middlewares ...Middleware) {
+	m.handlerMutex.Lock()
+	defer m.handlerMutex.Unlock()
+	m.middlewares = append(m.middlewares, middlewares...)
+	for action, handler := range m.handlers {
+		m.wrapped[action] = m.wrap(handler)
+	}
+}
+
+/** with the handlerMutex held */
+func (m *messageLooper) wrap(handler MessageHandler) MessageHandler {
+	for index := len(m.middlewares) - 1; index >= 0; index-- {
+		handler = m.middlewares[index](handler)
+	}
+	return handler
+}
+
+/** start the timer of the probe, once for every message, the one failing is a copy taken before the handler changes msg */
+func (m *messageLooper) watch(msg Message, timeout time.Duration) {
+	id := msg.GetInfo().GetID()
+	m.trickerMutex.Lock()
+	defer m.trickerMutex.Unlock()
+	if _, ok := m.probeTricker[id]; ok {
+		return
+	}
+	probe := msg.Copy()
+	m.probeTricker[id] = &TimeTricker{
+		msg: probe,
+		timer: m.clock.AfterFunc(timeout*time.Millisecond, func() {
+			if m.fire(m.probeTricker, id) {
+				probe.GetInfo().SetState(MS_Failed)
+				probe.GetInfo().SetHeader(HK_Error, ErrProbeTimeout.Error())
+				m.pushOwn(probe)
+			}
+			probe.Release()
+		}),
+	}
+}
+
+/** stop the timer of the probe the msg succeeds, the replies may carry its id as well */
+func (m *messageLooper) settle(msg Message) {
+	info := msg.GetInfo()
+	m.trickerMutex.Lock()
+	defer m.trickerMutex.Unlock()
+	for _, id := range []uint64{info.GetID(), info.GetReplyTo()} {
+		tricker, ok := m.probeTricker[id]
+		if ok {
+			delete(m.probeTricker, id)
+			tricker.Stop()
 		}
 	}
 }
+
+/** false when the timer was stopped before */
+func (m *messageLooper) fire(trickers map[uint64]*TimeTricker, id uint64) (ok bool) {
+	m.trickerMutex.Lock()
+	defer m.trickerMutex.Unlock()
+	_, ok = trickers[id]
+	delete(trickers, id)
+	return
+}
+
+/** probe the failed msg again after the backoff of its action, false when the policy gives up and msg goes to the dead letters */
+func (m *messageLooper) Retry(msg Message) bool {
+	action, _, _ := msg.GetInfo().Info()
+	_, policy, _ := m.handlerOf(action)
+	attempt := msg.GetVisitTimes()
+	if policy.MaxAttempts > 0 && attempt >= policy.MaxAttempts {
+		m.deadLetters.Bury(msg, "Retry Exhausted")
+		m.stats.count(action, func(stats *ActionStats) { stats.GaveUp++ })
+		if policy.GiveUp != nil {
+			m.gatherError(policy.GiveUp, msg)
+		} else {
+			utils.Logf(utils.Warningf, "Give Up After %d Attempts %v", attempt, msg)
+		}
+		return false
+	}
+	m.stats.count(action, func(stats *ActionStats) { stats.Retries++ })
+	retry := func() {
+		msg.GetInfo().SetTime(m.clock.Now())
+		msg.GetInfo().SetState(MS_Probe)
+		m.pushOwn(msg)
+	}
+	delay := policy.Delay(attempt)
+	if delay <= 0 {
+		retry()
+		return true
+	}
+	id := msg.GetInfo().GetID()
+	m.trickerMutex.Lock()
+	defer m.trickerMutex.Unlock()
+	if tricker, ok := m.retryTricker[id]; ok {
+		tricker.Stop()
+	}
+	m.retryTricker[id] = &TimeTricker{
+		msg: msg.Retain(),
+		timer: m.clock.AfterFunc(delay, func() {
+			if m.fire(m.retryTricker, id) {
+				retry()
+			}
+			msg.Release()
+		}),
+	}
+	return true
+}
+
+/** the deadlines, retries and timers go by the clock, before Loop and the timers */
+func (m *messageLooper) SetClock(clock Clock) {
+	m.clock = clock
+}
+func (m *messageLooper) Clock() Clock {
+	return m.clock
+}
+
+/** the messages given up by the retry policies */
+func (m *messageLooper) DeadLetters() DeadLetters {
+	return m.deadLetters
+}
+
+/** a copy, the handlers may change while looping */
 func (m *messageLooper) GetHandler() map[MessageAction]MessageHandler {
-	return m.handlers
+	m.handlerMutex.RLock()
+	defer m.handlerMutex.RUnlock()
+	handlers := make(map[MessageAction]MessageHandler, len(m.handlers))
+	for action, handler := range m.handlers {
+		handlers[action] = handler
+	}
+	return handlers
 }
 func (m *messageLooper) RemoveHandler(action MessageAction) {
+	m.handlerMutex.Lock()
+	defer m.handlerMutex.Unlock()
 	delete(m.handlers, action)
+	delete(m.wrapped, action)
 }
-func (m *messageLooper) SetInterval(timestamp time.Duration, handler func(t time.Time) error) {
-	timer := time.Tick(timestamp * time.Millisecond)
-	go func() {
-		for {
-			select {
-			case t := <-timer:
-				m.gatherError(func(msg Message) error {
-					return handler(t)
-				}, nil)
-			}
-		}
-	}()
+
+/** the handler inside the middlewares and the policy of action, anyHandler is false without a handler at all */
+func (m *messageLooper) handlerOf(action MessageAction) (handler MessageHandler, policy RetryPolicy, anyHandler bool) {
+	m.handlerMutex.RLock()
+	defer m.handlerMutex.RUnlock()
+	return m.wrapped[action], m.policies[action], len(m.handlers) > 0
+}
+
+/** the messages past their deadline go to the handler instead of the one of their action */
+func (m *messageLooper) SetExpiry(handler MessageHandler) {
+	m.expiry = handler
+}
+func (m *messageLooper) expire(msg Message) {
+	action, _, _ := msg.GetInfo().Info()
+	m.stats.count(action, func(stats *ActionStats) { stats.Expired++ })
+	if m.expiry == nil {
+		utils.Logf(utils.Warningf, "Drop Expired %v", msg)
+		msg.Release()
+		return
+	}
+	m.goHandle(m.wrap(m.expiry), msg)
 }
 func (m *messageLooper) Loop() {
+	select {
+	case <-m.done:
+		return
+	default:
+	}
+	m.counted.Do(func() {
+		atomic.AddInt32(&__loopers, 1)
+	})
 	for {
 		select {
-		case msg, isOpen := <-m.msgs:
+		case _, isOpen := <-m.ready:
 			if !isOpen {
-				utils.Logf(utils.Errf, "Message Loop Closed")
+				utils.Logf(utils.Infof, "Message Loop Drained")
 				m.term()
 				return
 			} else {
-				msg = msg.Copy()
+				msg := m.next()
+				if msg == nil {
+					continue
+				}
 				action, state, _ := msg.GetInfo().Info()
-				if action == MA_Term || len(m.handlers) <= 0 {
+				handler, policy, anyHandler := m.handlerOf(action)
+				if action == MA_Term || !anyHandler {
+					msg.Release()
+					m.cancel()
 					m.term()
 					return
 				}
+				if msg.GetInfo().Expired(m.clock.Now()) {
+					m.expire(msg)
+					continue
+				}
 				//for retry
-				tricker, ok := m.handleTricker[action]
-				if ok {
-					func(tricker TimeTricker, state MessageState, action MessageAction) {
-						switch state {
-						case MS_Probe:
-							if tricker.timer == nil {
-								tricker.timer = time.AfterFunc(
-									tricker.duration,
-									func() {
-										msg.GetInfo().SetState(MS_Failed)
-										tricker.Stop()
-										m.handleTricker[action] = tricker
-										m.Push(msg)
-									},
-								)
-							}
-							m.handleTricker[action] = tricker
-						case MS_Succeed:
-							tricker.Stop()
-							m.handleTricker[action] = tricker
-						}
-					}(tricker, state, action)
+				switch state {
+				case MS_Probe:
+					if policy.Timeout > 0 {
+						m.watch(msg, policy.Timeout)
+					}
+				case MS_Succeed:
+					m.settle(msg)
 				}
 
-				handler := m.handlers[action]
 				if handler != nil {
 					m.goHandle(handler, msg)
+				} else {
+					msg.Release()
 				}
 			}
 
@@ -146,24 +653,85 @@ func (m *messageLooper) Loop() {
 	}
 }
 
+/** stop at once, the messages still queued are left and the contexts of the handlers are cancelled, whatever the backpressure */
 func (m *messageLooper) Term() {
+	//the loop waiting for a worker goes on
+	m.cancel()
 	info := NewMessageInfo()
 	info.SetAcion(MA_Term)
-	m.Push(NewMessage(info))
+	err := m.push(NewMessage(info), func(msg Message) error {
+		select {
+		case m.lanes[PriorityOf(msg)] <- msg:
+			return nil
+		case <-m.done:
+			return ErrLooperClosed
+		}
+	})
+	if err != nil {
+		utils.Logf(utils.Warningf, "Term %v", err)
+	}
+}
+
+/*
+stop taking pushes, handle the messages already queued and wait for the handlers,
+the contexts of the ones still running after timeout milliseconds by the clock are cancelled
+*/
+func (m *messageLooper) Drain(timeout time.Duration) (err error) {
+	m.mutex.Lock()
+	closed := m.closed
+	m.closed = true
+	m.mutex.Unlock()
+	if !closed {
+		//the loop goes on until the pushes begun before are queued
+		go func() {
+			m.pushing.Wait()
+			close(m.ready)
+		}()
+	}
+	defer m.cancel()
+	expired := make(chan bool)
+	timer := m.clock.AfterFunc(timeout*time.Millisecond, func() {
+		close(expired)
+	})
+	defer timer.Stop()
+	select {
+	case <-m.done:
+	case <-expired:
+		return ErrDrainTimeout
+	}
+	handled := make(chan bool)
+	go func() {
+		m.inflight.Wait()
+		close(handled)
+	}()
+	select {
+	case <-handled:
+	case <-expired:
+		err = ErrDrainTimeout
+	}
+	return
 }
 
 func (m *messageLooper) term() {
-	if m.msgs != nil {
-		close(m.msgs)
-		m.msgs = nil
-	}
-	for _, tricker := range m.handleTricker {
-		if tricker.timer != nil {
-			tricker.timer.Stop()
-			tricker.timer = nil
+	m.mutex.Lock()
+	m.closed = true
+	m.mutex.Unlock()
+	m.trickerMutex.Lock()
+	for _, trickers := range []map[uint64]*TimeTricker{m.probeTricker, m.retryTricker} {
+		for id, tricker := range trickers {
+			delete(trickers, id)
+			tricker.Stop()
 		}
 	}
-	utils.CloseError()
+	m.trickerMutex.Unlock()
+	m.stopTimers()
+	for _, w := range m.workers {
+		w.close()
+	}
+	close(m.done)
+	if atomic.AddInt32(&__loopers, -1) <= 0 {
+		utils.CloseError()
+	}
 }
 
 type MessageHandler func(msg Message) error
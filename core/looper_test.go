@@ -1,9 +1,11 @@
 package core
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	utils "github.com/gargous/flitter/utils"
+	"strconv"
 	"testing"
 	"time"
 )
@@ -78,3 +80,433 @@ func TestMessageLooper(t *testing.T) {
 	looper.Loop()
 	t.Log(utils.Norf("End Msg Looper"))
 }
+
+func Test_MessageLooper_Deadline(t *testing.T) {
+	t.Log(utils.Norf("Start Msg Looper Deadline"))
+	looper := NewMessageLooper(10)
+	handled := make(chan string, 2)
+	expired := make(chan string, 2)
+	looper.AddHandler(0, MA_Refer, func(msg Message) error {
+		content, _ := msg.GetContent(0)
+		handled <- string(content)
+		return nil
+	})
+	looper.SetExpiry(func(msg Message) error {
+		content, _ := msg.GetContent(0)
+		expired <- string(content)
+		return nil
+	})
+	stale := NewMessage(NewMessageInfo())
+	stale.GetInfo().SetAcion(MA_Refer)
+	stale.GetInfo().SetDeadline(time.Now().Add(-time.Second))
+	stale.AppendContent([]byte("stale"))
+	fresh := NewMessage(NewMessageInfo())
+	fresh.GetInfo().SetAcion(MA_Refer)
+	fresh.GetInfo().SetDeadline(time.Now().Add(time.Minute))
+	fresh.AppendContent([]byte("fresh"))
+	looper.Push(stale)
+	looper.Push(fresh)
+	go looper.Loop()
+	if got := <-expired; got != "stale" {
+		t.Fatal(utils.Errf("Expired the wrong one:%s", got))
+	}
+	if got := <-handled; got != "fresh" {
+		t.Fatal(utils.Errf("Handled the wrong one:%s", got))
+	}
+	looper.Term()
+	t.Log(utils.Norf("End Msg Looper Deadline"))
+}
+
+func Test_MessageLooper_Priority(t *testing.T) {
+	t.Log(utils.Norf("Start Msg Looper Priority"))
+	push := func(looper *messageLooper, actions ...MessageAction) {
+		for _, action := range actions {
+			info := NewMessageInfo()
+			info.SetAcion(action)
+			looper.Push(NewMessage(info))
+		}
+	}
+	order := func(looper *messageLooper) (actions []MessageAction) {
+		for len(looper.ready) > 0 {
+			<-looper.ready
+			action, _, _ := looper.next().GetInfo().Info()
+			actions = append(actions, action)
+		}
+		return
+	}
+	looper := NewMessageLooper(10).(*messageLooper)
+	push(looper, MA_Update, MA_Update, MA_Refer, MA_Heartbeat)
+	info := NewMessageInfo()
+	info.SetAcion(MA_Update)
+	info.SetHeader(HK_Priority, strconv.Itoa(int(MP_High)))
+	looper.Push(NewMessage(info))
+	got := fmt.Sprint(order(looper))
+	want := fmt.Sprint([]MessageAction{MA_Heartbeat, MA_Update, MA_Refer, MA_Update, MA_Update})
+	if got != want {
+		t.Fatal(utils.Errf("Strict order %s, want %s", got, want))
+	}
+
+	looper = NewMessageLooper(10).(*messageLooper)
+	looper.SetSchedule(SCH_Weighted, 2, 1, 1)
+	push(looper, MA_Update, MA_Update, MA_Refer, MA_Refer, MA_Heartbeat, MA_Heartbeat, MA_Heartbeat)
+	got = fmt.Sprint(order(looper))
+	want = fmt.Sprint([]MessageAction{MA_Heartbeat, MA_Heartbeat, MA_Refer, MA_Update, MA_Heartbeat, MA_Refer, MA_Update})
+	if got != want {
+		t.Fatal(utils.Errf("Weighted order %s, want %s", got, want))
+	}
+	t.Log(utils.Norf("End Msg Looper Priority"))
+}
+
+func Benchmark_MessageLooper(b *testing.B) {
+	looper := NewMessageLooper(10)
+	done := make(chan bool, 10)
+	looper.AddHandler(0, MA_Update, func(msg Message) error {
+		done <- true
+		return nil
+	})
+	go looper.Loop()
+	msg := NewMessage(NewMessageInfo())
+	msg.GetInfo().SetAcion(MA_Update)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		looper.Push(msg)
+		<-done
+	}
+}
+
+func Test_MessageLooper_Retry(t *testing.T) {
+	t.Log(utils.Norf("Start Msg Looper Retry"))
+	policy := RetryPolicy{Backoff: 100, MaxBackoff: 300}
+	for attempt, want := range []time.Duration{100, 100, 200, 300, 300} {
+		if got := policy.Delay(attempt); got != want*time.Millisecond {
+			t.Fatal(utils.Errf("Delay of attempt %d is %v, want %v", attempt, got, want*time.Millisecond))
+		}
+	}
+	policy.Jitter = 0.5
+	for i := 0; i < 10; i++ {
+		if got := policy.Delay(3); got < 150*time.Millisecond || got > 450*time.Millisecond {
+			t.Fatal(utils.Errf("Jitter out of range:%v", got))
+		}
+	}
+
+	looper := NewMessageLooper(10)
+	failed := make(chan string, 10)
+	gaveup := make(chan string, 1)
+	looper.AddRetryHandler(RetryPolicy{
+		Timeout:     50,
+		MaxAttempts: 2,
+		Backoff:     10,
+		GiveUp: func(msg Message) error {
+			content, _ := msg.GetContent(0)
+			gaveup <- string(content)
+			return nil
+		},
+	}, MA_Lock, func(msg Message) error {
+		content, _ := msg.GetContent(0)
+		_, state, _ := msg.GetInfo().Info()
+		switch state {
+		case MS_Probe:
+			if string(content) == "quick" {
+				msg.GetInfo().SetState(MS_Succeed)
+				looper.Push(msg)
+			}
+		case MS_Failed:
+			failed <- string(content)
+			looper.Retry(msg)
+		}
+		return nil
+	})
+	go looper.Loop()
+	for _, name := range []string{"slow", "quick"} {
+		msg := NewMessage(NewMessageInfo())
+		msg.GetInfo().SetAcion(MA_Lock)
+		msg.AppendContent([]byte(name))
+		looper.Push(msg)
+	}
+	if got := <-gaveup; got != "slow" {
+		t.Fatal(utils.Errf("Gave up the wrong one:%s", got))
+	}
+	close(failed)
+	fails := []string{}
+	for name := range failed {
+		fails = append(fails, name)
+	}
+	if fmt.Sprint(fails) != "[slow slow]" {
+		t.Fatal(utils.Errf("Wrong failures:%v", fails))
+	}
+	if letters := looper.DeadLetters().List(); len(letters) != 1 || letters[0].LastError != ErrProbeTimeout.Error() {
+		t.Fatal(utils.Errf("Not in the dead letters:%v", letters))
+	}
+	looper.Term()
+	t.Log(utils.Norf("End Msg Looper Retry"))
+}
+
+func Test_MessageLooper_Middleware(t *testing.T) {
+	t.Log(utils.Norf("Start Msg Looper Middleware"))
+	looper := NewMessageLooper(10)
+	order := make(chan string, 10)
+	trace := func(name string) Middleware {
+		return func(next MessageHandler) MessageHandler {
+			return func(msg Message) error {
+				order <- name
+				return next(msg)
+			}
+		}
+	}
+	errs := make(chan string, 1)
+	looper.Use(trace("outer"), Recover())
+	looper.AddHandler(0, MA_Lock, func(msg Message) error {
+		_, state, _ := msg.GetInfo().Info()
+		if state == MS_Error {
+			content, _ := msg.GetContent(0)
+			errs <- string(content)
+			return nil
+		}
+		order <- "handler"
+		panic("boom")
+	})
+	looper.Use(trace("inner"))
+	go looper.Loop()
+	msg := NewMessage(NewMessageInfo())
+	msg.GetInfo().SetAcion(MA_Lock)
+	looper.Push(msg)
+	if got := <-errs; got != "Panic boom" {
+		t.Fatal(utils.Errf("Panic not recovered:%s", got))
+	}
+	steps := []string{}
+	for len(order) > 0 {
+		steps = append(steps, <-order)
+	}
+	if fmt.Sprint(steps) != "[outer inner handler outer inner]" {
+		t.Fatal(utils.Errf("Wrong order:%v", steps))
+	}
+	//used while looping
+	looper.AddHandler(0, MA_Update, func(msg Message) error {
+		order <- "update"
+		return nil
+	})
+	for i := 0; i < 10; i++ {
+		msg := NewMessage(NewMessageInfo())
+		msg.GetInfo().SetAcion(MA_Update)
+		looper.Push(msg)
+		looper.Use(func(next MessageHandler) MessageHandler { return next })
+	}
+	//through outer and inner too
+	for i := 0; i < 30; i++ {
+		<-order
+	}
+	looper.Term()
+	t.Log(utils.Norf("End Msg Looper Middleware"))
+}
+
+func Test_MessageLooper_Context(t *testing.T) {
+	t.Log(utils.Norf("Start Msg Looper Context"))
+	looper := NewMessageLooper(10)
+	done := make(chan error, 2)
+	looper.AddContextHandler(50, MA_Lock, func(ctx context.Context, msg Message) error {
+		if trace, _ := TraceOf(ctx); trace != "t1" {
+			t.Error(utils.Errf("Wrong trace:%s", trace))
+		}
+		<-ctx.Done()
+		done <- ctx.Err()
+		return nil
+	})
+	looper.AddContextHandler(0, MA_Update, func(ctx context.Context, msg Message) error {
+		<-ctx.Done()
+		done <- ctx.Err()
+		return nil
+	})
+	go looper.Loop()
+	msg := NewMessage(NewMessageInfo())
+	msg.GetInfo().SetAcion(MA_Lock)
+	msg.GetInfo().SetHeader(HK_Trace, "t1")
+	looper.Push(msg)
+	if err := <-done; err != context.DeadlineExceeded {
+		t.Fatal(utils.Errf("Not timed out:%v", err))
+	}
+	msg = NewMessage(NewMessageInfo())
+	msg.GetInfo().SetAcion(MA_Update)
+	looper.Push(msg)
+	time.Sleep(10 * time.Millisecond)
+	looper.Term()
+	if err := <-done; err != context.Canceled {
+		t.Fatal(utils.Errf("Not cancelled by term:%v", err))
+	}
+	t.Log(utils.Norf("End Msg Looper Context"))
+}
+
+func Test_MessageLooper_Workers(t *testing.T) {
+	t.Log(utils.Norf("Start Msg Looper Workers"))
+	looper := NewMessageLooper(10)
+	looper.SetWorkers(3, func(msg Message) string {
+		content, _ := msg.GetContent(0)
+		return string(content[:1])
+	})
+	handled := make(chan string, 20)
+	looper.AddHandler(0, MA_Update, func(msg Message) error {
+		content, _ := msg.GetContent(0)
+		//the first ones of every key take the longest
+		if content[1] == '0' {
+			time.Sleep(20 * time.Millisecond)
+		}
+		handled <- string(content)
+		return nil
+	})
+	go looper.Loop()
+	for i := 0; i < 4; i++ {
+		for _, key := range []string{"a", "b"} {
+			msg := NewMessage(NewMessageInfo())
+			msg.GetInfo().SetAcion(MA_Update)
+			msg.AppendContent([]byte(key + strconv.Itoa(i)))
+			looper.Push(msg)
+		}
+	}
+	next := map[byte]int{}
+	for i := 0; i < 8; i++ {
+		content := <-handled
+		if int(content[1]-'0') != next[content[0]] {
+			t.Fatal(utils.Errf("Out of order:%s", content))
+		}
+		next[content[0]]++
+	}
+	looper.Term()
+	t.Log(utils.Norf("End Msg Looper Workers"))
+}
+
+func Test_MessageLooper_WorkersPushBack(t *testing.T) {
+	t.Log(utils.Norf("Start Msg Looper Workers Push Back"))
+	looper := NewMessageLooper(1)
+	looper.SetWorkers(1, nil)
+	//the pushes back wait for room no longer than their backpressure
+	looper.SetBackpressure(Backpressure{Policy: OP_Block, Timeout: 20}, MA_Lock)
+	locked := make(chan bool, 20)
+	looper.AddHandler(0, MA_Update, func(msg Message) error {
+		for i := 0; i < 3; i++ {
+			lock := NewMessage(NewMessageInfo())
+			lock.GetInfo().SetAcion(MA_Lock)
+			looper.Push(lock)
+		}
+		return nil
+	})
+	looper.AddHandler(0, MA_Lock, func(msg Message) error {
+		locked <- true
+		return nil
+	})
+	go looper.Loop()
+	go func() {
+		for i := 0; i < 5; i++ {
+			msg := NewMessage(NewMessageInfo())
+			msg.GetInfo().SetAcion(MA_Update)
+			looper.Push(msg)
+		}
+	}()
+	handled := uint64(0)
+	for handled+looper.Overflows().TimedOut < 15 {
+		select {
+		case <-locked:
+			handled++
+		case <-time.After(time.Second):
+			t.Fatal(utils.Errf("Blocked by its own pushes:%d %+v", handled, looper.Overflows()))
+		}
+	}
+	looper.Term()
+	t.Log(utils.Norf("End Msg Looper Workers Push Back"))
+}
+
+func Test_MessageLooper_Drain(t *testing.T) {
+	t.Log(utils.Norf("Start Msg Looper Drain"))
+	looper := NewMessageLooper(10)
+	handled := make(chan string, 10)
+	looper.AddHandler(0, MA_Update, func(msg Message) error {
+		time.Sleep(10 * time.Millisecond)
+		content, _ := msg.GetContent(0)
+		handled <- string(content)
+		return nil
+	})
+	for i := 0; i < 5; i++ {
+		msg := NewMessage(NewMessageInfo())
+		msg.GetInfo().SetAcion(MA_Update)
+		msg.AppendContent([]byte(strconv.Itoa(i)))
+		looper.Push(msg)
+	}
+	go looper.Loop()
+	if err := looper.Drain(1000); err != nil {
+		t.Fatal(err)
+	}
+	if len(handled) != 5 {
+		t.Fatal(utils.Errf("Handled %d of 5", len(handled)))
+	}
+	msg := NewMessage(NewMessageInfo())
+	msg.GetInfo().SetAcion(MA_Update)
+	looper.Push(msg)
+	looper.Term()
+	if len(handled) != 5 {
+		t.Fatal(utils.Errf("Handled after drain"))
+	}
+
+	slow := NewMessageLooper(10)
+	slow.AddContextHandler(0, MA_Update, func(ctx context.Context, msg Message) error {
+		<-ctx.Done()
+		return nil
+	})
+	go slow.Loop()
+	msg = NewMessage(NewMessageInfo())
+	msg.GetInfo().SetAcion(MA_Update)
+	slow.Push(msg)
+	if err := slow.Drain(50); err != ErrDrainTimeout {
+		t.Fatal(utils.Errf("Drained a stuck handler:%v", err))
+	}
+
+	//the deadline goes by the clock
+	clock := NewFakeClock(time.Now())
+	stuck := NewMessageLooper(10)
+	stuck.SetClock(clock)
+	stuck.AddContextHandler(0, MA_Update, func(ctx context.Context, msg Message) error {
+		<-ctx.Done()
+		return nil
+	})
+	go stuck.Loop()
+	msg = NewMessage(NewMessageInfo())
+	msg.GetInfo().SetAcion(MA_Update)
+	stuck.Push(msg)
+	drained := make(chan error, 1)
+	go func() {
+		drained <- stuck.Drain(1000)
+	}()
+	select {
+	case err := <-drained:
+		t.Fatal(utils.Errf("Drained before the clock moved:%v", err))
+	case <-time.After(20 * time.Millisecond):
+	}
+	clock.Advance(1000 * time.Millisecond)
+	if err := <-drained; err != ErrDrainTimeout {
+		t.Fatal(utils.Errf("Not timed out by the clock:%v", err))
+	}
+
+	//the pushes waiting for room are let go once the looper terms
+	full := NewMessageLooper(1)
+	full.AddHandler(0, MA_Update, func(msg Message) error { return nil })
+	msg = NewMessage(NewMessageInfo())
+	msg.GetInfo().SetAcion(MA_Update)
+	full.Push(msg)
+	pushed := make(chan error, 1)
+	go func() {
+		msg := NewMessage(NewMessageInfo())
+		msg.GetInfo().SetAcion(MA_Update)
+		pushed <- full.TryPush(msg)
+	}()
+	time.Sleep(10 * time.Millisecond)
+	//the term is taken before the update lane
+	full.Term()
+	go full.Loop()
+	select {
+	case err := <-pushed:
+		if err != ErrLooperClosed {
+			t.Fatal(utils.Errf("Wrong push error:%v", err))
+		}
+	case <-time.After(time.Second):
+		t.Fatal(utils.Errf("Push left blocked after term"))
+	}
+	t.Log(utils.Norf("End Msg Looper Drain"))
+}
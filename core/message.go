@@ -2,10 +2,16 @@ package core
 
 import (
 	"bytes"
+	"crypto/rand"
+	"encoding/binary"
 	"errors"
 	"fmt"
 	common "github.com/gargous/flitter/common"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -32,8 +38,16 @@ const (
 	MA_User_Request
 )
 
+func (m MessageAction) Valid() bool {
+	if m > 0 && m <= MA_User_Request {
+		return true
+	}
+	_, ok := registeredName(m)
+	return ok
+}
+
 func (m MessageAction) Normalize() MessageAction {
-	if m > 16 {
+	if !m.Valid() {
 		m = MA_Undefine
 	}
 	return m
@@ -74,6 +88,9 @@ func (m MessageAction) String() (str string) {
 	case MA_Term:
 		return "MA_Term"
 	}
+	if name, ok := registeredName(m); ok {
+		return name
+	}
 	return ""
 }
 
@@ -90,6 +107,10 @@ const (
 	MS_Local
 )
 
+func (m MessageState) Valid() bool {
+	return m > 0 && m <= MS_Local
+}
+
 func (m MessageState) Normalize() MessageState {
 	if m > 6 {
 		m = MS_Probe
@@ -125,33 +146,97 @@ type Message interface {
 	GetContent(index int) (buf []byte, ok bool)
 	SetContents(buf [][]byte)
 	GetContents() (buf [][]byte)
+	AppendBuffer(buffer *Buffer)
+	Retain() Message
+	Release()
 	Copy() Message
 	String() string
+	MarshalJSON() ([]byte, error)
+	UnmarshalJSON(buf []byte) error
 }
 
 func NewMessage(info MessageInfo) Message {
 	msg := &message{
 		info:     info,
 		contents: make([][]byte, 0),
-		visit:    0}
+		visit:    0,
+		refs:     1}
 	return msg
 }
 
-/*(NULL)*/
+/*
+the one making the message holds the first reference, every looper queue holding it holds another,
+the pooled buffers go back when the last one is released, the messages never released are left to the gc
+*/
 type message struct {
 	info     MessageInfo
 	visit    int
 	contents [][]byte
+	refs     int32
+	buffers  []*Buffer
+	parent   *message
+	//the received ones go back to the pool with the slice of their frames
+	pooled bool
+	frames [][]byte
+}
+
+/*the messages received, so the deliverers do not make one for every message*/
+var __messagePool sync.Pool
+
+/** the message is put back when the last reference is released, so retain it to use it after */
+func newPooledMessage() *message {
+	if msg, ok := __messagePool.Get().(*message); ok {
+		msg.refs = 1
+		return msg
+	}
+	return &message{refs: 1, pooled: true}
 }
 
+/** the copy shares the bytes of the contents, so it keeps the message alive until released */
 func (m *message) Copy() Message {
 	msg := &message{
 		info:     m.info.Copy(),
-		contents: m.GetContents(),
+		contents: append([][]byte(nil), m.contents...),
 		visit:    m.visit,
+		refs:     1,
+		parent:   m,
 	}
+	m.Retain()
 	return msg
 }
+func (m *message) Retain() Message {
+	atomic.AddInt32(&m.refs, 1)
+	return m
+}
+func (m *message) Release() {
+	if atomic.AddInt32(&m.refs, -1) != 0 {
+		return
+	}
+	for _, buffer := range m.buffers {
+		buffer.Free()
+	}
+	m.buffers = nil
+	if m.parent != nil {
+		m.parent.Release()
+		m.parent = nil
+	}
+	if m.pooled {
+		for index := range m.frames {
+			m.frames[index] = nil
+		}
+		m.frames = m.frames[:0]
+		m.info = nil
+		m.contents = nil
+		m.visit = 0
+		__messagePool.Put(m)
+	}
+}
+
+/** the bytes of the buffer become the next content, and the buffer is freed with the message */
+func (m *message) AppendBuffer(buffer *Buffer) {
+	m.AppendContent(buffer.B)
+	m.buffers = append(m.buffers, buffer)
+}
 func (m *message) Visit() {
 	m.visit += 1
 }
@@ -206,15 +291,84 @@ type MessageInfo interface {
 	SetAcion(action MessageAction)
 	SetState(state MessageState)
 	SetTime(_time time.Time)
+	SetReplyTo(id uint64)
+	SetHeader(key string, value string)
+	DelHeader(key string)
+	GetID() uint64
+	GetReplyTo() uint64
+	GetHeader(key string) (value string, ok bool)
+	GetHeaders() map[string]string
+	SetDeadline(deadline time.Time)
+	GetDeadline() (deadline time.Time, ok bool)
+	Expired(now time.Time) bool
 	Copy() MessageInfo
 	Info() (action MessageAction, state MessageState, _time time.Time)
+	MarshalJSON() ([]byte, error)
+	UnmarshalJSON(buf []byte) error
+}
+
+/*the well known keys of the message headers*/
+const (
+	HK_Source  string = "src"
+	HK_Service string = "srv"
+	HK_Trace   string = "trace"
+	HK_Tenant  string = "tenant"
+	//the unix nanoseconds after which nobody wants the message
+	HK_Deadline string = "deadline"
+)
+
+/*bump it when the layout of the head changes*/
+const ProtocolVersion uint8 = 1
+
+const (
+	__InfoMagic byte = 0xF1
+	//action,state,time
+	__InfoLegacySize int = 10
+	//magic,version,action,state,time,id,replyto
+	__InfoSize int = 28
+	//the uint16 length before the headers
+	__InfoHeaderLenSize int = 2
+)
+
+/*the head was written by a peer speaking another protocol version*/
+type VersionError struct {
+	Version uint8
+	//the HK_Source and the state of the head, if they are where this version has them
+	Source string
+	State  MessageState
+}
+
+func (v *VersionError) Error() string {
+	return fmt.Sprintf("Protocol Version %d Mismatch With %d", v.Version, ProtocolVersion)
+}
+
+var (
+	__messageID uint64
+)
+
+func init() {
+	seed := make([]byte, 4)
+	_, err := rand.Read(seed)
+	if err != nil {
+		binary.BigEndian.PutUint32(seed, uint32(time.Now().UnixNano()))
+	}
+	__messageID = uint64(binary.BigEndian.Uint32(seed)) << 32
+}
+
+/*unique in this process, and the random high half keeps it apart from other nodes*/
+func NewMessageID() uint64 {
+	id := atomic.AddUint64(&__messageID, 1)
+	if id == 0 {
+		id = atomic.AddUint64(&__messageID, 1)
+	}
+	return id
 }
 
 func NewMessageInfo() MessageInfo {
 	return &messageInfo{
 		action: MA_Undefine,
 		state:  MS_Probe,
-		size:   10,
+		id:     NewMessageID(),
 	}
 }
 
@@ -223,14 +377,22 @@ type messageInfo struct {
 	action   MessageAction
 	state    MessageState
 	sendtime int64
-	size     int
+	id       uint64
+	replyto  uint64
+	headers  map[string]string
 }
 
 func (m *messageInfo) Copy() MessageInfo {
-	info := NewMessageInfo()
-	info.SetState(m.state)
-	info.SetAcion(m.action)
-	info.SetTime(time.Unix(0, m.sendtime))
+	info := &messageInfo{
+		action:   m.action,
+		state:    m.state,
+		sendtime: m.sendtime,
+		id:       m.id,
+		replyto:  m.replyto,
+	}
+	for key, value := range m.headers {
+		info.SetHeader(key, value)
+	}
 	return info
 }
 
@@ -241,36 +403,145 @@ func (m *messageInfo) Read(buf []byte) (int, error) {
 		err = errors.New("Invalid message info to read")
 		return 0, err
 	}
-	buf[0] = byte(m.action)
-	buf[1] = byte(m.state)
-	timebuf, err := common.Ecode(m.sendtime)
-	if err != nil {
+	if m.Size()-__InfoSize-__InfoHeaderLenSize > 0xffff {
+		err = errors.New("Too many message headers to read")
 		return 0, err
 	}
-	if len(timebuf) < 8 {
-		err = errors.New("Invalid message time to read")
-		return 0, err
-	}
-	for i := 2; i < m.Size(); i++ {
-		buf[i] = timebuf[i-2]
+	buf[0] = __InfoMagic
+	buf[1] = ProtocolVersion
+	buf[2] = byte(m.action)
+	buf[3] = byte(m.state)
+	binary.BigEndian.PutUint64(buf[4:12], uint64(m.sendtime))
+	binary.BigEndian.PutUint64(buf[12:20], m.id)
+	binary.BigEndian.PutUint64(buf[20:28], m.replyto)
+	headerbuf := buf[__InfoSize+__InfoHeaderLenSize:]
+	headerlen := 0
+	for _, key := range m.headerKeys() {
+		value := m.headers[key]
+		headerbuf[headerlen] = byte(len(key))
+		headerlen += 1
+		headerlen += copy(headerbuf[headerlen:], key)
+		binary.BigEndian.PutUint16(headerbuf[headerlen:], uint16(len(value)))
+		headerlen += 2
+		headerlen += copy(headerbuf[headerlen:], value)
 	}
+	binary.BigEndian.PutUint16(buf[__InfoSize:], uint16(headerlen))
 	return m.Size(), nil
 }
 
 /** write in from the buf */
 func (m *messageInfo) Write(buf []byte) (int, error) {
 	var err error
-	if len(buf) < m.Size() {
+	if len(buf) < __InfoLegacySize {
+		err = errors.New("Invalid message info to write")
+		return 0, err
+	}
+	m.headers = nil
+	//the heads sent before the magic and version exist
+	if buf[0] != __InfoMagic {
+		return m.writeLegacy(buf)
+	}
+	if len(buf) < __InfoSize+__InfoHeaderLenSize {
 		err = errors.New("Invalid message info to write")
 		return 0, err
 	}
-	m.action = MessageAction(buf[0]).Normalize()
-	buf = buf[1:]
-	m.state = MessageState(buf[0]).Normalize()
-	buf = buf[1:]
+	if buf[1] != ProtocolVersion {
+		verr := &VersionError{Version: buf[1], State: MessageState(buf[3])}
+		if m.writeHeaders(buf[__InfoSize:]) == nil {
+			verr.Source, _ = m.GetHeader(HK_Source)
+		}
+		m.headers = nil
+		return 0, verr
+	}
+	err = m.writeActionState(buf[2], buf[3])
+	if err != nil {
+		return 0, err
+	}
+	buf = buf[4:]
 	sendtime, err := common.ByteArrayToUInt64(buf)
+	if err != nil {
+		return 0, err
+	}
+	m.sendtime = int64(sendtime)
+	buf = buf[8:]
+	m.id, err = common.ByteArrayToUInt64(buf)
+	if err != nil {
+		return 0, err
+	}
+	buf = buf[8:]
+	m.replyto, err = common.ByteArrayToUInt64(buf)
+	if err != nil {
+		return 0, err
+	}
+	err = m.writeHeaders(buf[8:])
+	if err != nil {
+		return 0, err
+	}
+	return m.Size(), nil
+}
+
+/** the headers from the buf, beginning with their length */
+func (m *messageInfo) writeHeaders(buf []byte) (err error) {
+	headerlen, err := common.ByteArrayToUInt16(buf)
+	if err != nil {
+		return
+	}
+	buf = buf[2:]
+	if len(buf) < int(headerlen) {
+		err = errors.New("Invalid message headers to write")
+		return
+	}
+	buf = buf[:headerlen]
+	for len(buf) > 0 {
+		keylen := int(buf[0])
+		buf = buf[1:]
+		if len(buf) < keylen+2 {
+			err = errors.New("Invalid message header key to write")
+			return
+		}
+		key := string(buf[:keylen])
+		buf = buf[keylen:]
+		valuelen, _ := common.ByteArrayToUInt16(buf)
+		buf = buf[2:]
+		if len(buf) < int(valuelen) {
+			err = errors.New("Invalid message header value to write")
+			return
+		}
+		m.SetHeader(key, string(buf[:valuelen]))
+		buf = buf[valuelen:]
+	}
+	return
+}
+
+func (m *messageInfo) writeLegacy(buf []byte) (int, error) {
+	err := m.writeActionState(buf[0], buf[1])
+	if err != nil {
+		return 0, err
+	}
+	sendtime, err := common.ByteArrayToUInt64(buf[2:])
+	if err != nil {
+		return 0, err
+	}
 	m.sendtime = int64(sendtime)
-	return m.Size(), err
+	m.id = 0
+	m.replyto = 0
+	return __InfoLegacySize, nil
+}
+
+func (m *messageInfo) writeActionState(actionbuf byte, statebuf byte) (err error) {
+	action := MessageAction(actionbuf)
+	if !action.Valid() {
+		err = fmt.Errorf("Invalid message action %d to write", actionbuf)
+		return
+	}
+	state := MessageState(statebuf)
+	if !state.Valid() {
+		err = fmt.Errorf("Invalid message state %d to write", statebuf)
+		return
+	}
+	m.action = action
+	m.state = state
+	return
 }
 
 func (m *messageInfo) SetAcion(action MessageAction) {
@@ -288,6 +559,93 @@ func (m *messageInfo) SetTime(_time time.Time) {
 	return
 }
 
+func (m *messageInfo) SetReplyTo(id uint64) {
+	m.replyto = id
+	return
+}
+
+func (m *messageInfo) GetID() uint64 {
+	return m.id
+}
+
+func (m *messageInfo) GetReplyTo() uint64 {
+	return m.replyto
+}
+
+/** the key is cut to 255 bytes and the value to 65535 bytes */
+func (m *messageInfo) SetHeader(key string, value string) {
+	if len(key) > 0xff {
+		key = key[:0xff]
+	}
+	if len(value) > 0xffff {
+		value = value[:0xffff]
+	}
+	if m.headers == nil {
+		m.headers = make(map[string]string)
+	}
+	m.headers[key] = value
+	return
+}
+
+func (m *messageInfo) DelHeader(key string) {
+	delete(m.headers, key)
+	return
+}
+
+func (m *messageInfo) GetHeader(key string) (value string, ok bool) {
+	value, ok = m.headers[key]
+	return
+}
+
+func (m *messageInfo) GetHeaders() map[string]string {
+	headers := make(map[string]string)
+	for key, value := range m.headers {
+		headers[key] = value
+	}
+	return headers
+}
+
+/** the zero time clears the deadline */
+func (m *messageInfo) SetDeadline(deadline time.Time) {
+	if deadline.IsZero() {
+		m.DelHeader(HK_Deadline)
+		return
+	}
+	m.SetHeader(HK_Deadline, strconv.FormatInt(deadline.UnixNano(), 10))
+}
+
+func (m *messageInfo) GetDeadline() (deadline time.Time, ok bool) {
+	value, ok := m.headers[HK_Deadline]
+	if !ok {
+		return
+	}
+	nano, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		ok = false
+		return
+	}
+	deadline = time.Unix(0, nano)
+	return
+}
+
+/** the messages without a deadline never expire */
+func (m *messageInfo) Expired(now time.Time) bool {
+	deadline, ok := m.GetDeadline()
+	return ok && now.After(deadline)
+}
+
+func (m *messageInfo) headerKeys() []string {
+	if len(m.headers) == 0 {
+		return nil
+	}
+	keys := make([]string, 0, len(m.headers))
+	for key := range m.headers {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
 func (m *messageInfo) Info() (action MessageAction, state MessageState, _time time.Time) {
 	action = m.action
 	state = m.state
@@ -297,9 +655,17 @@ func (m *messageInfo) Info() (action MessageAction, state MessageState, _time ti
 
 func (m messageInfo) String() string {
 	timstr := time.Unix(0, m.sendtime).Format("2006.01.02 15:04:05")
-	str := fmt.Sprintf("MsgInfo[\n\taction:%v\n\tstate:%v\n\ttime:%s\n]", m.action, m.state, timstr)
+	headerstr := ""
+	for _, key := range m.headerKeys() {
+		headerstr += fmt.Sprintf("\n\t\t%s:%s", key, m.headers[key])
+	}
+	str := fmt.Sprintf("MsgInfo[\n\tid:%d\n\treplyto:%d\n\taction:%v\n\tstate:%v\n\ttime:%s\n\theaders:%s\n]", m.id, m.replyto, m.action, m.state, timstr, headerstr)
 	return str
 }
 func (m messageInfo) Size() int {
-	return m.size
+	size := __InfoSize + __InfoHeaderLenSize
+	for key, value := range m.headers {
+		size += 1 + len(key) + 2 + len(value)
+	}
+	return size
 }
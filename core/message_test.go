@@ -2,6 +2,7 @@ package core
 
 import (
 	"bytes"
+	"encoding/json"
 	utils "github.com/gargous/flitter/utils"
 	"github.com/kr/pretty"
 	"testing"
@@ -17,7 +18,7 @@ func Test_MessageInfo(t *testing.T) {
 	nowTime := time.Now()
 	info.SetTime(nowTime)
 	action, state, _time := info.Info()
-	if action == MA_Refer && state == MS_Probe && info.Size() == 10 && _time.Equal(nowTime) {
+	if action == MA_Refer && state == MS_Probe && info.Size() == __InfoSize+__InfoHeaderLenSize && _time.Equal(nowTime) {
 		t.Logf(utils.Infof("MessageInfo create succeed and now it's:%v", info))
 	} else {
 		t.Logf(utils.Errf("MessageInfo create failed and now it's:%v", info))
@@ -33,13 +34,15 @@ func Test_MessageInfo_Serialize(t *testing.T) {
 	info.SetState(MS_Probe)
 	nowTime := time.Now()
 	info.SetTime(nowTime)
+	info.SetReplyTo(7)
+	id := info.GetID()
 	serializer := NewSerializer()
 	_, buf, err := serializer.Encode(info)
 	if err != nil {
 		t.Log(utils.Errf("%v", err))
 		t.Fail()
 	}
-	if len(buf) < 2 || buf[0] != byte(MA_Refer) || buf[1] != byte(MS_Probe) {
+	if len(buf) < 4 || buf[1] != ProtocolVersion || buf[2] != byte(MA_Refer) || buf[3] != byte(MS_Probe) {
 		t.Logf(utils.Errf("Err and now info is:%v; buf is:%v", info, buf))
 		t.Fail()
 	} else {
@@ -53,7 +56,7 @@ func Test_MessageInfo_Serialize(t *testing.T) {
 		t.Fail()
 	}
 	action, state, _time := info.Info()
-	if action != MA_Refer || state != MS_Probe || !nowTime.Equal(_time) {
+	if action != MA_Refer || state != MS_Probe || !nowTime.Equal(_time) || info.GetID() != id || info.GetReplyTo() != 7 {
 		t.Logf(utils.Errf("Err and now info is:%v", info))
 		t.Fail()
 	} else {
@@ -92,3 +95,144 @@ func Test_Message_Serialize(t *testing.T) {
 	}
 	t.Log(utils.Norf("End MsgInfo Serialize"))
 }
+
+func Test_MessageInfo_ID(t *testing.T) {
+	t.Log(utils.Norf("Start MessageInfo ID"))
+	ids := make(map[uint64]bool)
+	for i := 0; i < 1000; i++ {
+		info := NewMessageInfo()
+		if info.GetID() == 0 || ids[info.GetID()] {
+			t.Fatal(utils.Errf("Duplicated id:%v", info))
+		}
+		ids[info.GetID()] = true
+	}
+	info := NewMessageInfo()
+	cinfo := info.Copy()
+	if cinfo.GetID() != info.GetID() {
+		t.Fatal(utils.Errf("Copy lost id:%v,%v", cinfo, info))
+	}
+	t.Log(utils.Norf("End MessageInfo ID"))
+}
+
+func Test_MessageInfo_Headers(t *testing.T) {
+	t.Log(utils.Norf("Start MessageInfo Headers"))
+	info := NewMessageInfo()
+	info.SetAcion(MA_Update)
+	info.SetHeader(HK_Source, "worker@127.0.0.1:8000")
+	info.SetHeader(HK_Trace, "t-1")
+	serializer := NewSerializer()
+	n, buf, err := serializer.Encode(info)
+	if err != nil || n != len(buf) {
+		t.Fatal(utils.Errf("Encode err:%v,%d,%d", err, n, len(buf)))
+	}
+	ninfo := NewMessageInfo()
+	_, err = serializer.Decode(ninfo, buf)
+	if err != nil {
+		t.Fatal(utils.Errf("Decode err:%v", err))
+	}
+	diff := pretty.Diff(ninfo.GetHeaders(), info.GetHeaders())
+	if len(diff) > 0 || ninfo.GetID() != info.GetID() {
+		t.Fatal(utils.Errf("Headers lost:%v,%v", ninfo, diff))
+	}
+	legacy := []byte{byte(MA_Heartbeat), byte(MS_Succeed), 0, 0, 0, 0, 0, 0, 0, 1}
+	linfo := NewMessageInfo()
+	n, err = serializer.Decode(linfo, legacy)
+	action, state, _ := linfo.Info()
+	if err != nil || n != len(legacy) || action != MA_Heartbeat || state != MS_Succeed || linfo.GetID() != 0 {
+		t.Fatal(utils.Errf("Legacy decode failed:%v,%v", err, linfo))
+	}
+	t.Log(utils.Norf("End MessageInfo Headers"))
+}
+
+func Test_MessageInfo_Version(t *testing.T) {
+	t.Log(utils.Norf("Start MessageInfo Version"))
+	serializer := NewSerializer()
+	info := NewMessageInfo()
+	info.SetHeader(HK_Source, "peer@127.0.0.1:8000")
+	_, buf, err := serializer.Encode(info)
+	if err != nil {
+		t.Fatal(utils.Errf("Encode err:%v", err))
+	}
+	buf[1] = ProtocolVersion + 1
+	_, err = serializer.Decode(NewMessageInfo(), buf)
+	if verr, ok := err.(*VersionError); !ok || verr.Source != "peer@127.0.0.1:8000" {
+		t.Fatal(utils.Errf("Should mismatch from the peer but:%v", err))
+	}
+	buf[1] = ProtocolVersion
+	buf[2] = 0xEE
+	_, err = serializer.Decode(NewMessageInfo(), buf)
+	if err == nil {
+		t.Fatal(utils.Errf("Unknown action decoded"))
+	}
+	t.Log(utils.Norf("End MessageInfo Version"))
+}
+
+func Test_Message_JSON(t *testing.T) {
+	t.Log(utils.Norf("Start Message JSON"))
+	info := NewMessageInfo()
+	info.SetAcion(MA_Lock)
+	info.SetState(MS_Failed)
+	info.SetTime(time.Now())
+	info.SetReplyTo(NewMessageID())
+	info.SetHeader(HK_Trace, "t1")
+	msg := NewMessage(info)
+	msg.AppendContent([]byte{0, 1, 0xff})
+	buf, err := json.Marshal(msg)
+	if err != nil {
+		t.Fatal(utils.Errf("Marshal err:%v", err))
+	}
+	if !bytes.Contains(buf, []byte(`"action":"MA_Lock"`)) || !bytes.Contains(buf, []byte(`"state":"MS_Failed"`)) || !bytes.Contains(buf, []byte(`"AAH/"`)) {
+		t.Fatal(utils.Errf("Wrong json:%s", buf))
+	}
+	got, err := ParseMessageJSON(buf)
+	if err != nil {
+		t.Fatal(utils.Errf("Unmarshal err:%v", err))
+	}
+	if got.GetInfo().String() != info.String() || got.GetInfo().GetID() != info.GetID() {
+		t.Fatal(utils.Errf("Info changed:\n%v\n%v", got.GetInfo(), info))
+	}
+	if content, _ := got.GetContent(0); !bytes.Equal(content, []byte{0, 1, 0xff}) {
+		t.Fatal(utils.Errf("Content changed:%v", content))
+	}
+	_, err = ParseMessageJSON([]byte(`{"info":{"action":"MA_Nothing"}}`))
+	if err == nil {
+		t.Fatal(utils.Errf("Should not parse an unknown action"))
+	}
+
+	cinfo := NewClientInfo("c1", NodePath("scene@127.0.0.1:8000"))
+	dinfo := NewDataInfo("pos")
+	dinfo.Value.Parse([]byte("1,2"))
+	buf, err = json.Marshal(struct {
+		Client ClientInfo
+		Data   DataInfo
+	}{cinfo, dinfo})
+	if err != nil {
+		t.Fatal(utils.Errf("Marshal err:%v", err))
+	}
+	var back struct {
+		Client ClientInfo
+		Data   DataInfo
+	}
+	err = json.Unmarshal(buf, &back)
+	if err != nil {
+		t.Fatal(utils.Errf("Unmarshal err:%v", err))
+	}
+	if back.Client.GetName() != cinfo.GetName() || back.Client.GetPath() != cinfo.GetPath() || back.Data.Key != "pos" || string(back.Data.Value.Data) != "1,2" || back.Data.Count != 1 {
+		t.Fatal(utils.Errf("Changed:%s\n%v", buf, back))
+	}
+	t.Log(utils.Norf("End Message JSON"))
+}
+
+func Benchmark_Serializer_Encode(b *testing.B) {
+	info := NewMessageInfo()
+	info.SetAcion(MA_Update)
+	info.SetHeader(HK_Source, "scene@127.0.0.1:8000")
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		_, head, err := NewSerializer().EncodePooled(info)
+		if err != nil {
+			b.Fatal(err)
+		}
+		head.Free()
+	}
+}
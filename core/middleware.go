@@ -0,0 +1,80 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	utils "github.com/gargous/flitter/common"
+	"time"
+)
+
+/*wrap the handlers of a looper, to do something before or after them or instead of them*/
+type Middleware func(next MessageHandler) MessageHandler
+
+/** the panic of the handler becomes its error */
+func Recover() Middleware {
+	return func(next MessageHandler) MessageHandler {
+		return func(msg Message) error {
+			return protect(next, msg)
+		}
+	}
+}
+
+func protect(handler MessageHandler, msg Message) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("Panic %v", r)
+		}
+	}()
+	return handler(msg)
+}
+
+/** log every message before it is handled */
+func Logging(name string) Middleware {
+	return func(next MessageHandler) MessageHandler {
+		return func(msg Message) error {
+			utils.Logf(utils.Norf, "%s Handle %v", name, msg.GetInfo())
+			return next(msg)
+		}
+	}
+}
+
+/** the messages in MS_Error are logged and go no further */
+func LogErrors(name string) Middleware {
+	return func(next MessageHandler) MessageHandler {
+		return func(msg Message) error {
+			if msg != nil {
+				if _, state, _ := msg.GetInfo().Info(); state == MS_Error {
+					utils.ErrIn(errors.New(msg.String()), name)
+					return nil
+				}
+			}
+			return next(msg)
+		}
+	}
+}
+
+/** report how long the handler of every action takes */
+func Timing(report func(action MessageAction, elapsed time.Duration)) Middleware {
+	return func(next MessageHandler) MessageHandler {
+		return func(msg Message) error {
+			start := time.Now()
+			err := next(msg)
+			action, _, _ := msg.GetInfo().Info()
+			report(action, time.Since(start))
+			return err
+		}
+	}
+}
+
+/** the messages not allowed are dropped with a warning */
+func Authorize(allow func(msg Message) bool) Middleware {
+	return func(next MessageHandler) MessageHandler {
+		return func(msg Message) error {
+			if !allow(msg) {
+				utils.Logf(utils.Warningf, "Unauthorized %v", msg.GetInfo())
+				return nil
+			}
+			return next(msg)
+		}
+	}
+}
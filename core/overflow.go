@@ -0,0 +1,164 @@
+package core
+
+import (
+	"errors"
+	utils "github.com/gargous/flitter/common"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	ErrQueueFull    error = errors.New("Queue Full")
+	ErrLooperClosed error = errors.New("Looper Closed")
+)
+
+/*what a push does when the lane of the message is full*/
+type OverflowPolicy uint8
+
+const (
+	//wait for room, no longer than the timeout if any
+	OP_Block OverflowPolicy = iota
+	//leave the pushed message
+	OP_DropNewest
+	//make room by leaving the longest queued one
+	OP_DropOldest
+	//refuse the pushed message with ErrQueueFull
+	OP_Error
+)
+
+func (o OverflowPolicy) String() string {
+	switch o {
+	case OP_Block:
+		return "OP_Block"
+	case OP_DropNewest:
+		return "OP_DropNewest"
+	case OP_DropOldest:
+		return "OP_DropOldest"
+	case OP_Error:
+		return "OP_Error"
+	}
+	return ""
+}
+
+/*the policy of a full lane, Timeout in milliseconds for OP_Block, 0 waits forever*/
+type Backpressure struct {
+	Policy  OverflowPolicy
+	Timeout time.Duration
+}
+
+/*how often the lanes of a looper were full, to size them*/
+type OverflowStats struct {
+	//the pushes finding their lane full
+	Full          uint64
+	TimedOut      uint64
+	DroppedNewest uint64
+	DroppedOldest uint64
+	Refused       uint64
+	//in the lanes and the queues of the workers
+	Queued   int
+	Capacity int
+}
+
+type overflowCounters struct {
+	full          uint64
+	timedOut      uint64
+	droppedNewest uint64
+	droppedOldest uint64
+	refused       uint64
+}
+
+/** the policy of the actions given, or of every other action without them, before Loop */
+func (m *messageLooper) SetBackpressure(backpressure Backpressure, actions ...MessageAction) {
+	if len(actions) <= 0 {
+		m.backpressure = backpressure
+		return
+	}
+	for _, action := range actions {
+		m.backpressures[action] = backpressure
+	}
+}
+
+func (m *messageLooper) backpressureOf(msg Message) Backpressure {
+	action, _, _ := msg.GetInfo().Info()
+	if backpressure, ok := m.backpressures[action]; ok {
+		return backpressure
+	}
+	return m.backpressure
+}
+
+/** queue msg in its lane by the backpressure of its action */
+func (m *messageLooper) enqueue(msg Message) (err error) {
+	lane := m.lanes[PriorityOf(msg)]
+	select {
+	case lane <- msg:
+		return
+	default:
+	}
+	atomic.AddUint64(&m.overflows.full, 1)
+	backpressure := m.backpressureOf(msg)
+	switch backpressure.Policy {
+	case OP_DropNewest:
+		atomic.AddUint64(&m.overflows.droppedNewest, 1)
+		return ErrQueueFull
+	case OP_Error:
+		atomic.AddUint64(&m.overflows.refused, 1)
+		return ErrQueueFull
+	case OP_DropOldest:
+		for {
+			select {
+			case lane <- msg:
+				return
+			default:
+			}
+			select {
+			case oldest := <-lane:
+				//the token of msg is sent after, so one less is left in ready
+				select {
+				case <-m.ready:
+				default:
+				}
+				atomic.AddUint64(&m.overflows.droppedOldest, 1)
+				utils.Logf(utils.Warningf, "Drop Oldest %v", oldest.GetInfo())
+				oldest.Release()
+			default:
+			}
+		}
+	}
+	//the ones still waiting when the looper terms are let go
+	if backpressure.Timeout <= 0 {
+		select {
+		case lane <- msg:
+		case <-m.done:
+			err = ErrLooperClosed
+		}
+		return
+	}
+	timer := time.NewTimer(backpressure.Timeout * time.Millisecond)
+	defer timer.Stop()
+	select {
+	case lane <- msg:
+	case <-timer.C:
+		atomic.AddUint64(&m.overflows.timedOut, 1)
+		err = ErrQueueFull
+	case <-m.done:
+		err = ErrLooperClosed
+	}
+	return
+}
+
+func (m *messageLooper) Overflows() (stats OverflowStats) {
+	stats.Full = atomic.LoadUint64(&m.overflows.full)
+	stats.TimedOut = atomic.LoadUint64(&m.overflows.timedOut)
+	stats.DroppedNewest = atomic.LoadUint64(&m.overflows.droppedNewest)
+	stats.DroppedOldest = atomic.LoadUint64(&m.overflows.droppedOldest)
+	stats.Refused = atomic.LoadUint64(&m.overflows.refused)
+	for _, lane := range m.lanes {
+		stats.Queued += len(lane)
+		stats.Capacity += cap(lane)
+	}
+	for _, w := range m.workers {
+		stats.Queued += w.queued()
+		stats.Capacity += w.size
+	}
+	return
+}
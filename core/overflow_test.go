@@ -0,0 +1,133 @@
+package core
+
+import (
+	utils "github.com/gargous/flitter/common"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func Test_MessageLooper_Backpressure(t *testing.T) {
+	t.Log(utils.Norf("Start Msg Looper Backpressure"))
+	newMsg := func(action MessageAction, content string) Message {
+		msg := NewMessage(NewMessageInfo())
+		msg.GetInfo().SetAcion(action)
+		msg.AppendContent([]byte(content))
+		//all in the same lane
+		msg.GetInfo().SetHeader(HK_Priority, strconv.Itoa(int(MP_Low)))
+		return msg
+	}
+	looper := NewMessageLooper(2)
+	looper.SetBackpressure(Backpressure{Policy: OP_Error})
+	looper.SetBackpressure(Backpressure{Policy: OP_DropOldest}, MA_Update)
+	looper.SetBackpressure(Backpressure{Policy: OP_Block, Timeout: 20}, MA_Lock)
+	for _, content := range []string{"1", "2", "3"} {
+		if err := looper.TryPush(newMsg(MA_Update, content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	start := time.Now()
+	if err := looper.TryPush(newMsg(MA_Lock, "lock")); err != ErrQueueFull || time.Since(start) < 20*time.Millisecond {
+		t.Fatal(utils.Errf("Not timed out:%v", err))
+	}
+	if err := looper.TryPush(newMsg(MA_Unlock, "unlock")); err != ErrQueueFull {
+		t.Fatal(utils.Errf("Not refused:%v", err))
+	}
+	stats := looper.Overflows()
+	if stats.Full != 3 || stats.DroppedOldest != 1 || stats.TimedOut != 1 || stats.Refused != 1 || stats.Queued != 2 {
+		t.Fatal(utils.Errf("Wrong stats:%+v", stats))
+	}
+
+	handled := make(chan string, 10)
+	//one worker keeps them in order
+	looper.SetWorkers(1, nil)
+	looper.AddHandler(0, MA_Update, func(msg Message) error {
+		content, _ := msg.GetContent(0)
+		handled <- string(content)
+		return nil
+	})
+	go looper.Loop()
+	if err := looper.Drain(1000); err != nil {
+		t.Fatal(err)
+	}
+	if len(handled) != 2 || <-handled != "2" || <-handled != "3" {
+		t.Fatal(utils.Errf("The oldest not dropped"))
+	}
+	if err := looper.TryPush(newMsg(MA_Update, "4")); err != ErrLooperClosed {
+		t.Fatal(utils.Errf("Pushed after drain:%v", err))
+	}
+
+	//the term waits for room in its lane even if the backpressure refuses the rest
+	refusing := NewMessageLooper(1)
+	refusing.SetBackpressure(Backpressure{Policy: OP_Error})
+	refusing.AddHandler(0, MA_Update, func(msg Message) error { return nil })
+	term := NewMessage(NewMessageInfo())
+	term.GetInfo().SetAcion(MA_Term)
+	full := newMsg(MA_Update, "full")
+	full.GetInfo().SetHeader(HK_Priority, strconv.Itoa(int(PriorityOf(term))))
+	refusing.Push(full)
+	go refusing.Term()
+	time.Sleep(10 * time.Millisecond)
+	stopped := make(chan bool)
+	go func() {
+		refusing.Loop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Fatal(utils.Errf("Term refused"))
+	}
+
+	//the queues of the workers are bounded, so the lanes fill up behind a busy worker
+	busy := NewMessageLooper(1)
+	busy.SetBackpressure(Backpressure{Policy: OP_Error})
+	busy.SetWorkers(1, nil)
+	release := make(chan bool)
+	busy.AddHandler(0, MA_Update, func(msg Message) error {
+		<-release
+		return nil
+	})
+	go busy.Loop()
+	refused := 0
+	for i := 0; i < 10 && refused == 0; i++ {
+		if busy.TryPush(newMsg(MA_Update, strconv.Itoa(i))) == ErrQueueFull {
+			refused++
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	overflows := busy.Overflows()
+	if refused == 0 || overflows.Queued != 2 || overflows.Capacity != int(__PriorityCount)+1 {
+		t.Fatal(utils.Errf("Queued past the workers:%+v", overflows))
+	}
+	if workers := busy.Stats().Workers; len(workers) != 1 || workers[0] != 1 {
+		t.Fatal(utils.Errf("Wrong worker stats:%v", workers))
+	}
+	close(release)
+	busy.Term()
+
+	//the errors gathered are not refused with the pushes
+	own := NewMessageLooper(1).(*messageLooper)
+	own.SetBackpressure(Backpressure{Policy: OP_Error})
+	errs := make(chan string, 1)
+	own.AddHandler(0, MA_Update, func(msg Message) error {
+		if _, state, _ := msg.GetInfo().Info(); state == MS_Error {
+			content, _ := msg.GetContent(1)
+			errs <- string(content)
+		}
+		return nil
+	})
+	own.Push(newMsg(MA_Update, "full"))
+	own.gatherError(func(msg Message) error { return ErrProbeTimeout }, newMsg(MA_Update, "failed"))
+	go own.Loop()
+	select {
+	case err := <-errs:
+		if err != ErrProbeTimeout.Error() {
+			t.Fatal(utils.Errf("Wrong error:%s", err))
+		}
+	case <-time.After(time.Second):
+		t.Fatal(utils.Errf("The error gathered refused:%+v", own.Overflows()))
+	}
+	own.Term()
+	t.Log(utils.Norf("End Msg Looper Backpressure"))
+}
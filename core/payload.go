@@ -0,0 +1,202 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+)
+
+/*
+the fields of a payload are tagged with the index of their content:
+
+	type movePayload struct {
+		Name string  `flitter:"0"`
+		X    float32 `flitter:"1"`
+	}
+
+strings and []byte are kept as they are, numbers and bools are written as text,
+and a field with Bytes() ([]byte, error) and Parse(interface{}) error (like common.DataItem)
+is written by itself
+*/
+const __PayloadTag string = "flitter"
+
+type payloadBytes interface {
+	Bytes() ([]byte, error)
+}
+type payloadParser interface {
+	Parse(value interface{}) error
+}
+
+type payloadField struct {
+	name  string
+	index int
+	value reflect.Value
+}
+
+func payloadFields(v interface{}, settable bool) (fields []payloadField, err error) {
+	value := reflect.ValueOf(v)
+	if value.Kind() == reflect.Ptr {
+		if value.IsNil() {
+			err = fmt.Errorf("Payload %T Is Nil", v)
+			return
+		}
+		value = value.Elem()
+	} else if settable {
+		err = fmt.Errorf("Payload %T Should Be A Pointer", v)
+		return
+	}
+	if value.Kind() != reflect.Struct {
+		err = fmt.Errorf("Payload %T Should Be A Struct", v)
+		return
+	}
+	vtype := value.Type()
+	used := make(map[int]string)
+	for i := 0; i < vtype.NumField(); i++ {
+		field := vtype.Field(i)
+		tag, ok := field.Tag.Lookup(__PayloadTag)
+		if !ok || tag == "-" {
+			continue
+		}
+		index, perr := strconv.Atoi(tag)
+		if perr != nil || index < 0 {
+			err = fmt.Errorf("Payload %T Field %s Has Invalid Index %q", v, field.Name, tag)
+			return
+		}
+		if other, ok := used[index]; ok {
+			err = fmt.Errorf("Payload %T Fields %s And %s Share Index %d", v, other, field.Name, index)
+			return
+		}
+		if field.PkgPath != "" {
+			err = fmt.Errorf("Payload %T Field %s Is Not Exported", v, field.Name)
+			return
+		}
+		used[index] = field.Name
+		fields = append(fields, payloadField{
+			name:  field.Name,
+			index: index,
+			value: value.Field(i),
+		})
+	}
+	return
+}
+
+/** write the tagged fields of v into the contents of msg, the other contents are kept */
+func (s *Serializer) Pack(msg Message, v interface{}) (err error) {
+	fields, err := payloadFields(v, false)
+	if err != nil {
+		return
+	}
+	contents := msg.GetContents()
+	size := len(contents)
+	for _, field := range fields {
+		if field.index >= size {
+			size = field.index + 1
+		}
+	}
+	bufs := make([][]byte, size)
+	copy(bufs, contents)
+	for i := len(contents); i < size; i++ {
+		bufs[i] = []byte{}
+	}
+	for _, field := range fields {
+		bufs[field.index], err = packField(field.value)
+		if err != nil {
+			err = fmt.Errorf("Payload %T Field %s: %v", v, field.name, err)
+			return
+		}
+	}
+	msg.SetContents(bufs)
+	return
+}
+
+/** read the contents of msg into the tagged fields of v, which must be a pointer */
+func (s *Serializer) Unpack(msg Message, v interface{}) (err error) {
+	fields, err := payloadFields(v, true)
+	if err != nil {
+		return
+	}
+	contents := msg.GetContents()
+	for _, field := range fields {
+		if field.index >= len(contents) {
+			err = fmt.Errorf("Payload %T Field %s Wants Content %d But Message Has %d", v, field.name, field.index, len(contents))
+			return
+		}
+	}
+	for _, field := range fields {
+		err = unpackField(field.value, contents[field.index])
+		if err != nil {
+			err = fmt.Errorf("Payload %T Field %s: %v", v, field.name, err)
+			return
+		}
+	}
+	return
+}
+
+func packField(value reflect.Value) (buf []byte, err error) {
+	addr := value
+	if !addr.CanAddr() {
+		addr = reflect.New(value.Type()).Elem()
+		addr.Set(value)
+	}
+	if marshaler, ok := addr.Addr().Interface().(payloadBytes); ok {
+		return marshaler.Bytes()
+	}
+	switch value.Kind() {
+	case reflect.String:
+		buf = []byte(value.String())
+	case reflect.Slice:
+		if value.Type().Elem().Kind() != reflect.Uint8 {
+			err = errors.New("Unsupported Type " + value.Type().String())
+			return
+		}
+		buf = append([]byte{}, value.Bytes()...)
+	case reflect.Bool:
+		buf = []byte(strconv.FormatBool(value.Bool()))
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		buf = []byte(strconv.FormatInt(value.Int(), 10))
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		buf = []byte(strconv.FormatUint(value.Uint(), 10))
+	case reflect.Float32, reflect.Float64:
+		buf = []byte(strconv.FormatFloat(value.Float(), 'g', -1, value.Type().Bits()))
+	default:
+		err = errors.New("Unsupported Type " + value.Type().String())
+	}
+	return
+}
+
+func unpackField(value reflect.Value, buf []byte) (err error) {
+	if parser, ok := value.Addr().Interface().(payloadParser); ok {
+		return parser.Parse(buf)
+	}
+	text := string(buf)
+	switch value.Kind() {
+	case reflect.String:
+		value.SetString(text)
+	case reflect.Slice:
+		if value.Type().Elem().Kind() != reflect.Uint8 {
+			err = errors.New("Unsupported Type " + value.Type().String())
+			return
+		}
+		value.SetBytes(append([]byte{}, buf...))
+	case reflect.Bool:
+		var b bool
+		b, err = strconv.ParseBool(text)
+		value.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		var i int64
+		i, err = strconv.ParseInt(text, 10, value.Type().Bits())
+		value.SetInt(i)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		var u uint64
+		u, err = strconv.ParseUint(text, 10, value.Type().Bits())
+		value.SetUint(u)
+	case reflect.Float32, reflect.Float64:
+		var f float64
+		f, err = strconv.ParseFloat(text, value.Type().Bits())
+		value.SetFloat(f)
+	default:
+		err = errors.New("Unsupported Type " + value.Type().String())
+	}
+	return
+}
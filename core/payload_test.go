@@ -0,0 +1,94 @@
+package core
+
+import (
+	"github.com/gargous/flitter/common"
+	"github.com/kr/pretty"
+	"testing"
+)
+
+type testPayload struct {
+	Name  string          `flitter:"0"`
+	Path  NodePath        `flitter:"1"`
+	X     float32         `flitter:"3"`
+	Hits  int             `flitter:"4"`
+	Alive bool            `flitter:"5"`
+	Data  common.DataItem `flitter:"6"`
+	Skip  string
+}
+
+func Test_Payload(t *testing.T) {
+	t.Log(common.Norf("Start Payload"))
+	serializer := NewSerializer()
+	in := testPayload{
+		Name:  "tom",
+		Path:  "a@127.0.0.1:8000",
+		X:     1.5,
+		Hits:  -3,
+		Alive: true,
+		Skip:  "not sent",
+	}
+	err := in.Data.Parse([]int{1, 2})
+	if err != nil {
+		t.Fatal(common.Errf("Parse err:%v", err))
+	}
+	msg := NewMessage(NewMessageInfo())
+	err = serializer.Pack(msg, in)
+	if err != nil {
+		t.Fatal(common.Errf("Pack err:%v", err))
+	}
+	if len(msg.GetContents()) != 7 {
+		t.Fatal(common.Errf("Pack wrong arity:%v", msg))
+	}
+	var out testPayload
+	err = serializer.Unpack(msg, &out)
+	if err != nil {
+		t.Fatal(common.Errf("Unpack err:%v", err))
+	}
+	in.Skip = ""
+	diff := pretty.Diff(in.Data.Data, out.Data.Data)
+	in.Data, out.Data = common.DataItem{}, common.DataItem{}
+	diff = append(diff, pretty.Diff(in, out)...)
+	if len(diff) > 0 {
+		t.Fatal(common.Errf("Unpack lost:%v", diff))
+	}
+
+	msg.SetContents(msg.GetContents()[:4])
+	err = serializer.Unpack(msg, &out)
+	if err == nil {
+		t.Fatal(common.Errf("Unpack short message"))
+	}
+	msg.SetContents([][]byte{[]byte("a"), []byte("b"), []byte(""), []byte("1"), []byte("x"), []byte("true"), []byte("")})
+	err = serializer.Unpack(msg, &out)
+	if err == nil {
+		t.Fatal(common.Errf("Unpack bad int"))
+	}
+	t.Log(common.Infof("Unpack bad int:%v", err))
+	err = serializer.Unpack(msg, out)
+	if err == nil {
+		t.Fatal(common.Errf("Unpack into a value"))
+	}
+	t.Log(common.Norf("End Payload"))
+}
+
+func Test_ClientDataInfo(t *testing.T) {
+	t.Log(common.Norf("Start ClientDataInfo"))
+	cInfo := NewClientInfo("tom", "a@127.0.0.1:8000")
+	dInfo := NewDataInfo("pos")
+	dInfo.Value.Parse([]byte("1,2"))
+	dInfo.Count = -1
+	msg := NewMessage(NewMessageInfo())
+	cInfo.AppendToMsg(msg)
+	err := dInfo.AppendToMsg(msg)
+	if err != nil {
+		t.Fatal(common.Errf("Append err:%v", err))
+	}
+	var ncInfo ClientInfo
+	var ndInfo DataInfo
+	if !ncInfo.Parse(msg) || !ndInfo.Parse(msg) {
+		t.Fatal(common.Errf("Parse failed:%v", msg))
+	}
+	if ncInfo.GetName() != cInfo.GetName() || ndInfo.Key != "pos" || ndInfo.Count != -1 || string(ndInfo.Value.Data) != "1,2" {
+		t.Fatal(common.Errf("Parse lost:%v,%v", ncInfo, ndInfo))
+	}
+	t.Log(common.Norf("End ClientDataInfo"))
+}
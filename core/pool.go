@@ -0,0 +1,86 @@
+package core
+
+import (
+	"compress/flate"
+	"io"
+	"sync"
+)
+
+/*the buffers are pooled by the power of two they round up to, the bigger ones are left to the gc*/
+const (
+	__PoolMinShift uint = 6
+	__PoolMaxShift uint = 20
+)
+
+var __bufferPools [__PoolMaxShift - __PoolMinShift + 1]sync.Pool
+
+/*a byte slice borrowed from the pool, Free gives it back*/
+type Buffer struct {
+	B     []byte
+	class int
+}
+
+/** B is size long, its contents are whatever the last user left */
+func GetBuffer(size int) *Buffer {
+	class := bufferClass(size)
+	if class < 0 {
+		return &Buffer{B: make([]byte, size), class: -1}
+	}
+	if buffer, ok := __bufferPools[class].Get().(*Buffer); ok {
+		buffer.B = buffer.B[:size]
+		return buffer
+	}
+	return &Buffer{
+		B:     make([]byte, size, 1<<(uint(class)+__PoolMinShift)),
+		class: class,
+	}
+}
+
+/** the buffer must not be used after */
+func (b *Buffer) Free() {
+	if b == nil || b.class < 0 || cap(b.B) != 1<<(uint(b.class)+__PoolMinShift) {
+		return
+	}
+	b.B = b.B[:0]
+	__bufferPools[b.class].Put(b)
+}
+
+func bufferClass(size int) int {
+	for shift := __PoolMinShift; shift <= __PoolMaxShift; shift++ {
+		if size <= 1<<shift {
+			return int(shift - __PoolMinShift)
+		}
+	}
+	return -1
+}
+
+/*the flate writers and readers allocate hundreds of kilobytes each, so they are reused*/
+var (
+	__flateWriters sync.Pool
+	__flateReaders sync.Pool
+)
+
+func getFlateWriter(w io.Writer) (writer *flate.Writer, err error) {
+	if pooled, ok := __flateWriters.Get().(*flate.Writer); ok {
+		pooled.Reset(w)
+		return pooled, nil
+	}
+	return flate.NewWriter(w, flate.DefaultCompression)
+}
+
+func putFlateWriter(writer *flate.Writer) {
+	__flateWriters.Put(writer)
+}
+
+func getFlateReader(r io.Reader) io.ReadCloser {
+	if pooled, ok := __flateReaders.Get().(io.ReadCloser); ok {
+		if pooled.(flate.Resetter).Reset(r, nil) == nil {
+			return pooled
+		}
+	}
+	return flate.NewReader(r)
+}
+
+func putFlateReader(reader io.ReadCloser) {
+	__flateReaders.Put(reader)
+}
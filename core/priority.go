@@ -0,0 +1,87 @@
+package core
+
+import (
+	"strconv"
+	"sync"
+)
+
+/*the lane of the looper a message waits in*/
+type MessagePriority uint8
+
+const (
+	MP_High MessagePriority = iota
+	MP_Normal
+	MP_Low
+	__PriorityCount
+)
+
+/*the header setting the priority of one message over the one of its action*/
+const HK_Priority string = "prio"
+
+func (m MessagePriority) String() (str string) {
+	switch m {
+	case MP_High:
+		str = "High"
+	case MP_Normal:
+		str = "Normal"
+	case MP_Low:
+		str = "Low"
+	default:
+		str = "Priority" + strconv.Itoa(int(m))
+	}
+	return
+}
+
+/*how the looper picks the lane to take the next message from*/
+type Schedule uint8
+
+const (
+	_ Schedule = iota
+	//the higher lanes always go first
+	SCH_Strict
+	//every lane takes as many messages as its weight in turn
+	SCH_Weighted
+)
+
+var (
+	__priorityMutex sync.RWMutex
+	__priorities    map[MessageAction]MessagePriority = map[MessageAction]MessagePriority{
+		MA_Init:      MP_High,
+		MA_Heartbeat: MP_High,
+		MA_Crash:     MP_High,
+		MA_Term:      MP_High,
+		MA_Update:    MP_Low,
+	}
+)
+
+/** the default lane of the action in every looper */
+func SetActionPriority(action MessageAction, priority MessagePriority) {
+	if priority >= __PriorityCount {
+		priority = MP_Low
+	}
+	__priorityMutex.Lock()
+	defer __priorityMutex.Unlock()
+	__priorities[action] = priority
+}
+
+/** the header first, then the action, and normal for the rest */
+func PriorityOf(msg Message) MessagePriority {
+	info := msg.GetInfo()
+	if value, ok := info.GetHeader(HK_Priority); ok {
+		priority, err := strconv.Atoi(value)
+		if err == nil && priority >= 0 {
+			if priority >= int(__PriorityCount) {
+				return MP_Low
+			}
+			return MessagePriority(priority)
+		}
+	}
+	action, _, _ := info.Info()
+	__priorityMutex.RLock()
+	defer __priorityMutex.RUnlock()
+	priority, ok := __priorities[action]
+	if !ok {
+		return MP_Normal
+	}
+	return priority
+}
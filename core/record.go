@@ -0,0 +1,252 @@
+package core
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+/*the first bytes of a recording, the last one is the version of the layout*/
+var __recordMagic []byte = []byte{'F', 'L', 'R', 1}
+
+const (
+	__RecordSent byte = 1 << iota
+)
+
+/*the frames larger are not recorded nor read back*/
+var __MaxRecordFrame uint32 = 64 << 20
+
+var (
+	ErrRecordTooLarge error = errors.New("Record Frame Too Large")
+	ErrRecordTooMany  error = errors.New("Record Too Many Frames")
+)
+
+/*one message passed by a deliverer*/
+type Record struct {
+	Time  time.Time `json:"time"`
+	Sent  bool      `json:"sent"`
+	Local string    `json:"local,omitempty"`
+	Peer  string    `json:"peer,omitempty"`
+	Msg   Message   `json:"msg"`
+}
+
+/*a record read to its end but its message not decoded, like one of an action not registered here, the next record can still be read*/
+type RecordError struct {
+	Time time.Time
+	Err  error
+}
+
+func (r *RecordError) Error() string {
+	return fmt.Sprintf("Record At %v:%v", r.Time, r.Err)
+}
+
+/*write the records of the messages one after another*/
+type Recorder interface {
+	Record(record Record) error
+	Close() error
+}
+
+func NewRecorder(writer io.Writer) Recorder {
+	return &recorder{
+		writer: bufio.NewWriter(writer),
+	}
+}
+
+/** the file at path is truncated */
+func CreateRecorder(path string) (Recorder, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	r := NewRecorder(file).(*recorder)
+	r.closer = file
+	return r, nil
+}
+
+type recorder struct {
+	mutex   sync.Mutex
+	writer  *bufio.Writer
+	closer  io.Closer
+	started bool
+}
+
+/*
+[flags][time int64][local uint16+bytes][peer uint16+bytes][frames uint16]
+then every frame as [uint32+bytes], the first frame is the head of the message
+*/
+func (r *recorder) Record(record Record) (err error) {
+	_, head, err := NewSerializer().Encode(record.Msg.GetInfo())
+	if err != nil {
+		return
+	}
+	frames := append([][]byte{head}, record.Msg.GetContents()...)
+	//the count is written in 16 bits
+	if len(frames) > 0xffff {
+		return ErrRecordTooMany
+	}
+	for _, frame := range frames {
+		if uint64(len(frame)) > uint64(__MaxRecordFrame) {
+			return ErrRecordTooLarge
+		}
+	}
+	buffer := bytes.NewBuffer(nil)
+	var flags byte
+	if record.Sent {
+		flags |= __RecordSent
+	}
+	buffer.WriteByte(flags)
+	binary.Write(buffer, binary.BigEndian, record.Time.UnixNano())
+	for _, str := range []string{record.Local, record.Peer} {
+		if len(str) > 0xffff {
+			str = str[:0xffff]
+		}
+		binary.Write(buffer, binary.BigEndian, uint16(len(str)))
+		buffer.WriteString(str)
+	}
+	binary.Write(buffer, binary.BigEndian, uint16(len(frames)))
+	for _, frame := range frames {
+		binary.Write(buffer, binary.BigEndian, uint32(len(frame)))
+		buffer.Write(frame)
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if !r.started {
+		_, err = r.writer.Write(__recordMagic)
+		if err != nil {
+			return
+		}
+		r.started = true
+	}
+	_, err = r.writer.Write(buffer.Bytes())
+	if err != nil {
+		return
+	}
+	return r.writer.Flush()
+}
+
+func (r *recorder) Close() (err error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	err = r.writer.Flush()
+	if r.closer != nil {
+		cerr := r.closer.Close()
+		if err == nil {
+			err = cerr
+		}
+	}
+	return
+}
+
+/*read the records back in the order they were written*/
+type RecordReader interface {
+	Next() (record Record, err error)
+}
+
+/** io.EOF from Next when all records are read */
+func NewRecordReader(reader io.Reader) (RecordReader, error) {
+	r := &recordReader{
+		reader: bufio.NewReader(reader),
+	}
+	magic := make([]byte, len(__recordMagic))
+	_, err := io.ReadFull(r.reader, magic)
+	if err == io.EOF {
+		return r, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(magic, __recordMagic) {
+		return nil, errors.New("Not A Recording")
+	}
+	return r, nil
+}
+
+type recordReader struct {
+	reader *bufio.Reader
+}
+
+func (r *recordReader) Next() (record Record, err error) {
+	flags, err := r.reader.ReadByte()
+	if err != nil {
+		return
+	}
+	record.Sent = flags&__RecordSent != 0
+	var nano int64
+	err = r.read(&nano)
+	if err != nil {
+		return
+	}
+	record.Time = time.Unix(0, nano)
+	strs := make([]string, 2)
+	for index := range strs {
+		var size uint16
+		err = r.read(&size)
+		if err != nil {
+			return
+		}
+		buf := make([]byte, size)
+		_, err = io.ReadFull(r.reader, buf)
+		if err != nil {
+			err = r.unexpected(err)
+			return
+		}
+		strs[index] = string(buf)
+	}
+	record.Local, record.Peer = strs[0], strs[1]
+	var count uint16
+	err = r.read(&count)
+	if err != nil {
+		return
+	}
+	if count < 1 {
+		err = errors.New("No Info In This Record")
+		return
+	}
+	frames := make([][]byte, count)
+	for index := range frames {
+		var size uint32
+		err = r.read(&size)
+		if err != nil {
+			return
+		}
+		//a corrupt size allocates nothing
+		if size > __MaxRecordFrame {
+			err = ErrRecordTooLarge
+			return
+		}
+		frames[index] = make([]byte, size)
+		_, err = io.ReadFull(r.reader, frames[index])
+		if err != nil {
+			err = r.unexpected(err)
+			return
+		}
+	}
+	info := NewMessageInfo()
+	_, err = NewSerializer().Decode(info, frames[0])
+	if err != nil {
+		err = &RecordError{Time: record.Time, Err: err}
+		return
+	}
+	record.Msg = NewMessage(info)
+	record.Msg.SetContents(frames[1:])
+	return
+}
+
+func (r *recordReader) read(value interface{}) error {
+	return r.unexpected(binary.Read(r.reader, binary.BigEndian, value))
+}
+
+/** a record cut in the middle is not the end of the recording */
+func (r *recordReader) unexpected(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
@@ -0,0 +1,90 @@
+package core
+
+import (
+	"bytes"
+	"encoding/binary"
+	utils "github.com/gargous/flitter/common"
+	"io"
+	"testing"
+	"time"
+)
+
+func Test_Recorder(t *testing.T) {
+	t.Log(utils.Norf("Start Recorder"))
+	buffer := bytes.NewBuffer(nil)
+	recorder := NewRecorder(buffer)
+	now := time.Now()
+	info := NewMessageInfo()
+	info.SetAcion(MA_Update)
+	info.SetHeader(HK_Source, "scene@127.0.0.1:8000")
+	msg := NewMessage(info)
+	msg.AppendContent([]byte("pos"))
+	msg.AppendContent([]byte{})
+	err := recorder.Record(Record{Time: now, Sent: true, Local: "127.0.0.1:8001", Peer: "127.0.0.1:7001", Msg: msg})
+	if err != nil {
+		t.Fatal(utils.Errf("Record err:%v", err))
+	}
+	err = recorder.Record(Record{Time: now.Add(time.Second), Msg: NewMessage(NewMessageInfo())})
+	if err != nil {
+		t.Fatal(utils.Errf("Record err:%v", err))
+	}
+
+	reader, err := NewRecordReader(bytes.NewReader(buffer.Bytes()))
+	if err != nil {
+		t.Fatal(utils.Errf("Reader err:%v", err))
+	}
+	record, err := reader.Next()
+	if err != nil {
+		t.Fatal(utils.Errf("Next err:%v", err))
+	}
+	if !record.Sent || !record.Time.Equal(now) || record.Local != "127.0.0.1:8001" || record.Peer != "127.0.0.1:7001" {
+		t.Fatal(utils.Errf("Wrong record:%v", record))
+	}
+	if record.Msg.GetInfo().GetID() != info.GetID() || len(record.Msg.GetContents()) != 2 {
+		t.Fatal(utils.Errf("Wrong message:%v", record.Msg))
+	}
+	if source, _ := record.Msg.GetInfo().GetHeader(HK_Source); source != "scene@127.0.0.1:8000" {
+		t.Fatal(utils.Errf("Header lost:%v", record.Msg))
+	}
+	record, err = reader.Next()
+	if err != nil || record.Sent {
+		t.Fatal(utils.Errf("Wrong second record:%v %v", record, err))
+	}
+	if _, err = reader.Next(); err != io.EOF {
+		t.Fatal(utils.Errf("Should end but:%v", err))
+	}
+	//the action of the first message is not one known here
+	tampered := append([]byte(nil), buffer.Bytes()...)
+	head := len(__recordMagic) + 1 + 8 + 2 + len("127.0.0.1:8001") + 2 + len("127.0.0.1:7001") + 2 + 4
+	tampered[head+2] = 0xff
+	reader, _ = NewRecordReader(bytes.NewReader(tampered))
+	if _, err = reader.Next(); err == nil {
+		t.Fatal(utils.Errf("Unknown action read"))
+	}
+	if rerr, ok := err.(*RecordError); !ok || !rerr.Time.Equal(now) {
+		t.Fatal(utils.Errf("Not a record error:%v", err))
+	}
+	if record, err = reader.Next(); err != nil || !record.Time.Equal(now.Add(time.Second)) {
+		t.Fatal(utils.Errf("Not read on after the bad record:%v %v", record, err))
+	}
+	reader, _ = NewRecordReader(bytes.NewReader(buffer.Bytes()[:buffer.Len()-1]))
+	reader.Next()
+	if _, err = reader.Next(); err != io.ErrUnexpectedEOF {
+		t.Fatal(utils.Errf("Should be cut but:%v", err))
+	}
+	//a size past the limit is not allocated
+	huge := append([]byte(nil), buffer.Bytes()...)
+	binary.BigEndian.PutUint32(huge[head-4:], 0xffffffff)
+	reader, _ = NewRecordReader(bytes.NewReader(huge))
+	if _, err = reader.Next(); err != ErrRecordTooLarge {
+		t.Fatal(utils.Errf("Should be too large but:%v", err))
+	}
+	many := NewMessage(NewMessageInfo())
+	for i := 0; i < 0xffff; i++ {
+		many.AppendContent(nil)
+	}
+	if err = recorder.Record(Record{Time: now, Msg: many}); err != ErrRecordTooMany {
+		t.Fatal(utils.Errf("Should be too many but:%v", err))
+	}
+	t.Log(utils.Norf("End Recorder"))
+}
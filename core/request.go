@@ -0,0 +1,86 @@
+package core
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+var (
+	ErrRequestTimeout error = errors.New("Request Timeout")
+)
+
+/*match the replies to the requests by the message id*/
+type Requester interface {
+	Request(send func(msg Message) error, msg Message, timeout time.Duration) (reply Message, err error)
+	Resolve(msg Message) (ok bool)
+	Pending() int
+}
+
+func NewRequester() Requester {
+	return &requester{
+		pending: make(map[uint64]chan Message),
+	}
+}
+
+type requester struct {
+	mutex   sync.Mutex
+	pending map[uint64]chan Message
+}
+
+/** send the msg and wait for the message replying to it, timeout is in milliseconds, Release the reply when done with it */
+func (r *requester) Request(send func(msg Message) error, msg Message, timeout time.Duration) (reply Message, err error) {
+	id := msg.GetInfo().GetID()
+	wait := make(chan Message, 1)
+	r.mutex.Lock()
+	r.pending[id] = wait
+	r.mutex.Unlock()
+	defer func() {
+		r.mutex.Lock()
+		delete(r.pending, id)
+		r.mutex.Unlock()
+	}()
+	err = send(msg)
+	if err != nil {
+		return
+	}
+	timer := time.NewTimer(timeout * time.Millisecond)
+	defer timer.Stop()
+	select {
+	case reply = <-wait:
+	case <-timer.C:
+		err = ErrRequestTimeout
+	}
+	return
+}
+
+/** hand the msg to the request waiting for it, retained until the one requesting releases it, false if nobody is waiting */
+func (r *requester) Resolve(msg Message) (ok bool) {
+	if msg == nil {
+		return
+	}
+	replyto := msg.GetInfo().GetReplyTo()
+	if replyto == 0 {
+		return
+	}
+	r.mutex.Lock()
+	wait, ok := r.pending[replyto]
+	r.mutex.Unlock()
+	if !ok {
+		return
+	}
+	//retained before the one requesting may release it
+	msg.Retain()
+	select {
+	case wait <- msg:
+	default:
+		msg.Release()
+	}
+	return
+}
+
+func (r *requester) Pending() int {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return len(r.pending)
+}
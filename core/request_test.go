@@ -0,0 +1,65 @@
+package core
+
+import (
+	utils "github.com/gargous/flitter/common"
+	"sync/atomic"
+	"testing"
+)
+
+func Test_Requester(t *testing.T) {
+	t.Log(utils.Norf("Start Requester"))
+	requester := NewRequester()
+	info := NewMessageInfo()
+	info.SetAcion(MA_Refer)
+	reply, err := requester.Request(func(msg Message) error {
+		rinfo := NewMessageInfo()
+		rinfo.SetAcion(MA_Refer)
+		rinfo.SetState(MS_Succeed)
+		rinfo.SetReplyTo(msg.GetInfo().GetID())
+		other := NewMessageInfo()
+		other.SetReplyTo(msg.GetInfo().GetID() + 1)
+		go func() {
+			if requester.Resolve(NewMessage(other)) {
+				t.Error(utils.Errf("Resolved a message nobody waits for"))
+			}
+			requester.Resolve(NewMessage(rinfo))
+		}()
+		return nil
+	}, NewMessage(info), 1000)
+	if err != nil {
+		t.Fatal(utils.Errf("Request err:%v", err))
+	}
+	if reply.GetInfo().GetReplyTo() != info.GetID() {
+		t.Fatal(utils.Errf("Wrong reply:%v", reply))
+	}
+	reply.Release()
+	_, err = requester.Request(func(msg Message) error {
+		return nil
+	}, NewMessage(NewMessageInfo()), 10)
+	if err != ErrRequestTimeout {
+		t.Fatal(utils.Errf("Should timeout but:%v", err))
+	}
+	if requester.Pending() != 0 {
+		t.Fatal(utils.Errf("Pending requests left:%d", requester.Pending()))
+	}
+	t.Log(utils.Norf("End Requester"))
+}
+
+func Test_Requester_Retain(t *testing.T) {
+	t.Log(utils.Norf("Start Requester Retain"))
+	//the reply is retained once for the one waiting, whoever releases first
+	waiting := &requester{pending: make(map[uint64]chan Message)}
+	wait := make(chan Message, 1)
+	waiting.pending[1] = wait
+	received := newPooledMessage()
+	received.info = NewMessageInfo()
+	received.info.SetReplyTo(1)
+	waiting.Resolve(received)
+	waiting.Resolve(received)
+	if atomic.LoadInt32(&received.refs) != 2 || <-wait != received {
+		t.Fatal(utils.Errf("Wrong references:%d", received.refs))
+	}
+	received.Release()
+	received.Release()
+	t.Log(utils.Norf("End Requester Retain"))
+}
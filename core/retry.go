@@ -0,0 +1,52 @@
+package core
+
+import (
+	"math/rand"
+	"time"
+)
+
+/*
+how the looper waits for the probes of an action and probes them again,
+the durations are in milliseconds like the maxHandleTime of AddHandler
+*/
+type RetryPolicy struct {
+	//a probe not succeeded in time comes back as MS_Failed, 0 waits forever
+	Timeout time.Duration
+	//the failures before giving up, 0 never gives up
+	MaxAttempts int
+	//the wait before the first retry, doubled for every next one
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+	//the part of the wait randomly added or taken, from 0 to 1
+	Jitter float64
+	//called instead of probing again once MaxAttempts is reached
+	GiveUp MessageHandler
+}
+
+/** the wait before probing again after the attempt-th failure */
+func (r RetryPolicy) Delay(attempt int) time.Duration {
+	if r.Backoff <= 0 {
+		return 0
+	}
+	delay := r.Backoff * time.Millisecond
+	for i := 1; i < attempt && i < 32; i++ {
+		delay *= 2
+		if r.MaxBackoff > 0 && delay >= r.MaxBackoff*time.Millisecond {
+			break
+		}
+	}
+	if r.MaxBackoff > 0 && delay > r.MaxBackoff*time.Millisecond {
+		delay = r.MaxBackoff * time.Millisecond
+	}
+	if r.Jitter > 0 {
+		jitter := r.Jitter
+		if jitter > 1 {
+			jitter = 1
+		}
+		delay += time.Duration(float64(delay) * jitter * (rand.Float64()*2 - 1))
+	}
+	if delay < 0 {
+		delay = 0
+	}
+	return delay
+}
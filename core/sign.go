@@ -0,0 +1,57 @@
+package core
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+)
+
+/*the header telling the last content is the hmac of the head and the other contents*/
+const HK_Signature string = "mac"
+
+const __SignatureAlgorithm string = "sha256"
+
+var (
+	ErrUnsigned error = errors.New("Message Is Not Signed")
+	ErrTampered error = errors.New("Message Signature Mismatch")
+)
+
+/** the hmac over the encoded head and every content, each behind its length */
+func signFrames(secret []byte, head []byte, contents [][]byte) []byte {
+	mac := hmac.New(sha256.New, secret)
+	size := make([]byte, 4)
+	binary.BigEndian.PutUint32(size, uint32(len(head)))
+	mac.Write(size)
+	mac.Write(head)
+	for _, content := range contents {
+		binary.BigEndian.PutUint32(size, uint32(len(content)))
+		mac.Write(size)
+		mac.Write(content)
+	}
+	return mac.Sum(nil)
+}
+
+/** check the frames received, and give back the contents without the signature */
+func verifyFrames(secret []byte, info MessageInfo, bufs [][]byte) (contents [][]byte, err error) {
+	algorithm, ok := info.GetHeader(HK_Signature)
+	if !ok || algorithm != __SignatureAlgorithm || len(bufs) < 2 {
+		err = ErrUnsigned
+		return
+	}
+	contents = bufs[1 : len(bufs)-1]
+	if !signedFrames(secret, bufs) {
+		err = ErrTampered
+		return
+	}
+	info.DelHeader(HK_Signature)
+	return
+}
+
+/** the last frame is the hmac of the others, whatever version the head is of */
+func signedFrames(secret []byte, bufs [][]byte) bool {
+	if len(bufs) < 2 {
+		return false
+	}
+	return hmac.Equal(bufs[len(bufs)-1], signFrames(secret, bufs[0], bufs[1:len(bufs)-1]))
+}
@@ -0,0 +1,192 @@
+package core
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+/*the upper bounds of the latency buckets, the last bucket takes the slower ones*/
+var __LatencyBounds = []time.Duration{
+	time.Millisecond,
+	2 * time.Millisecond,
+	5 * time.Millisecond,
+	10 * time.Millisecond,
+	20 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	200 * time.Millisecond,
+	500 * time.Millisecond,
+	time.Second,
+	2 * time.Second,
+	5 * time.Second,
+	10 * time.Second,
+}
+
+/*how long the handlers took, Counts has one more bucket than Bounds*/
+type Histogram struct {
+	Bounds []time.Duration
+	Counts []uint64
+	Count  uint64
+	Sum    time.Duration
+	Max    time.Duration
+}
+
+func newHistogram() Histogram {
+	return Histogram{
+		Bounds: __LatencyBounds,
+		Counts: make([]uint64, len(__LatencyBounds)+1),
+	}
+}
+
+func (h *Histogram) observe(elapsed time.Duration) {
+	index := sort.Search(len(h.Bounds), func(i int) bool {
+		return elapsed <= h.Bounds[i]
+	})
+	h.Counts[index]++
+	h.Count++
+	h.Sum += elapsed
+	if elapsed > h.Max {
+		h.Max = elapsed
+	}
+}
+
+func (h Histogram) Mean() time.Duration {
+	if h.Count <= 0 {
+		return 0
+	}
+	return h.Sum / time.Duration(h.Count)
+}
+
+/** the upper bound of the bucket holding the q part of the counts, Max past the last bound */
+func (h Histogram) Quantile(q float64) time.Duration {
+	if h.Count <= 0 {
+		return 0
+	}
+	rank := uint64(q * float64(h.Count))
+	if rank >= h.Count {
+		rank = h.Count - 1
+	}
+	var seen uint64
+	for index, count := range h.Counts {
+		seen += count
+		if seen > rank {
+			if index < len(h.Bounds) && h.Bounds[index] < h.Max {
+				return h.Bounds[index]
+			}
+			return h.Max
+		}
+	}
+	return h.Max
+}
+
+/*what the looper did with the messages of one action*/
+type ActionStats struct {
+	//by the state the messages were handled in
+	Handled map[MessageState]uint64
+	Errors  uint64
+	Retries uint64
+	GaveUp  uint64
+	Expired uint64
+	Latency Histogram
+}
+
+func (a ActionStats) copy() ActionStats {
+	handled := make(map[MessageState]uint64, len(a.Handled))
+	for state, count := range a.Handled {
+		handled[state] = count
+	}
+	a.Handled = handled
+	a.Latency.Counts = append([]uint64(nil), a.Latency.Counts...)
+	return a
+}
+
+/*a snapshot of a looper*/
+type LooperStats struct {
+	Time        time.Time
+	Lanes       [__PriorityCount]int
+	Workers     []int
+	InFlight    int64
+	DeadLetters int
+	Overflows   OverflowStats
+	Actions     map[MessageAction]ActionStats
+}
+
+func (l LooperStats) String() string {
+	str := fmt.Sprintf("Looper Stats:[\n\ttime:%v\n\tlanes:%v\n\tworkers:%v\n\tin flight:%d\n\tdead letters:%d\n\toverflows:%+v",
+		l.Time, l.Lanes, l.Workers, l.InFlight, l.DeadLetters, l.Overflows)
+	actions := make([]MessageAction, 0, len(l.Actions))
+	for action := range l.Actions {
+		actions = append(actions, action)
+	}
+	sort.Slice(actions, func(i, j int) bool { return actions[i] < actions[j] })
+	for _, action := range actions {
+		stats := l.Actions[action]
+		str += fmt.Sprintf("\n\t%v:[handled:%v errors:%d retries:%d gave up:%d expired:%d mean:%v p99:%v max:%v]",
+			action, stats.Handled, stats.Errors, stats.Retries, stats.GaveUp, stats.Expired,
+			stats.Latency.Mean(), stats.Latency.Quantile(0.99), stats.Latency.Max)
+	}
+	return str + "\n]"
+}
+
+type looperStats struct {
+	mutex    sync.Mutex
+	inflight int64
+	actions  map[MessageAction]*ActionStats
+}
+
+/** with the mutex held */
+func (l *looperStats) of(action MessageAction) *ActionStats {
+	if l.actions == nil {
+		l.actions = make(map[MessageAction]*ActionStats)
+	}
+	stats, ok := l.actions[action]
+	if !ok {
+		stats = &ActionStats{
+			Handled: make(map[MessageState]uint64),
+			Latency: newHistogram(),
+		}
+		l.actions[action] = stats
+	}
+	return stats
+}
+
+func (l *looperStats) handled(action MessageAction, state MessageState, elapsed time.Duration, err error) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	stats := l.of(action)
+	stats.Handled[state]++
+	stats.Latency.observe(elapsed)
+	if err != nil {
+		stats.Errors++
+	}
+}
+
+func (l *looperStats) count(action MessageAction, counter func(stats *ActionStats)) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	counter(l.of(action))
+}
+
+/** the counters go on from the looper start, the lanes are the messages queued right now */
+func (m *messageLooper) Stats() (stats LooperStats) {
+	stats.Time = m.clock.Now()
+	for index, lane := range m.lanes {
+		stats.Lanes[index] = len(lane)
+	}
+	for _, w := range m.workers {
+		stats.Workers = append(stats.Workers, w.queued())
+	}
+	stats.InFlight = atomic.LoadInt64(&m.stats.inflight)
+	stats.DeadLetters = m.deadLetters.Len()
+	stats.Overflows = m.Overflows()
+	m.stats.mutex.Lock()
+	defer m.stats.mutex.Unlock()
+	stats.Actions = make(map[MessageAction]ActionStats, len(m.stats.actions))
+	for action, astats := range m.stats.actions {
+		stats.Actions[action] = astats.copy()
+	}
+	return
+}
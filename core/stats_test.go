@@ -0,0 +1,57 @@
+package core
+
+import (
+	"errors"
+	utils "github.com/gargous/flitter/common"
+	"testing"
+	"time"
+)
+
+func Test_Histogram(t *testing.T) {
+	t.Log(utils.Norf("Start Histogram"))
+	h := newHistogram()
+	for _, elapsed := range []time.Duration{500 * time.Microsecond, 3 * time.Millisecond, 3 * time.Millisecond, 30 * time.Second} {
+		h.observe(elapsed)
+	}
+	if h.Count != 4 || h.Counts[0] != 1 || h.Counts[2] != 2 || h.Counts[len(h.Bounds)] != 1 {
+		t.Fatal(utils.Errf("Wrong buckets:%v", h.Counts))
+	}
+	if h.Quantile(0.5) != 5*time.Millisecond || h.Quantile(0.99) != 30*time.Second || h.Max != 30*time.Second {
+		t.Fatal(utils.Errf("Wrong quantiles:%v %v", h.Quantile(0.5), h.Quantile(0.99)))
+	}
+	t.Log(utils.Norf("End Histogram"))
+}
+
+func Test_MessageLooper_Stats(t *testing.T) {
+	t.Log(utils.Norf("Start Msg Looper Stats"))
+	looper := NewMessageLooper(10)
+	looper.AddHandler(0, MA_Update, func(msg Message) error {
+		_, state, _ := msg.GetInfo().Info()
+		if state == MS_Probe {
+			return errors.New("Update Failed")
+		}
+		return nil
+	})
+	for _, state := range []MessageState{MS_Probe, MS_Succeed, MS_Succeed} {
+		msg := NewMessage(NewMessageInfo())
+		msg.GetInfo().SetAcion(MA_Update)
+		msg.GetInfo().SetState(state)
+		looper.Push(msg)
+	}
+	if stats := looper.Stats(); stats.Lanes[MP_Low] != 3 {
+		t.Fatal(utils.Errf("Wrong lanes:%v", stats.Lanes))
+	}
+	go looper.Loop()
+	if err := looper.Drain(1000); err != nil {
+		t.Fatal(err)
+	}
+	stats := looper.Stats()
+	update := stats.Actions[MA_Update]
+	//the error comes back as MS_Error unless the drain began before
+	handled := update.Handled[MS_Probe] + update.Handled[MS_Succeed] + update.Handled[MS_Error]
+	if update.Handled[MS_Probe] != 1 || update.Handled[MS_Succeed] != 2 || update.Errors != 1 || update.Latency.Count != handled {
+		t.Fatal(utils.Errf("Wrong stats:%v", stats))
+	}
+	t.Log(stats)
+	t.Log(utils.Norf("End Msg Looper Stats"))
+}
@@ -0,0 +1,411 @@
+package core
+
+import (
+	"errors"
+	"io"
+	"strconv"
+	"sync"
+	"time"
+)
+
+/*the headers of the messages carrying a stream*/
+const (
+	//the id of the opening message, on every chunk and ack
+	HK_Stream string = "stream"
+	//0 opens the stream, the data chunks count from 1
+	HK_StreamSeq string = "seq"
+	//on the last chunk
+	HK_StreamEnd string = "eos"
+	//the last chunk read by the far side
+	HK_StreamAck string = "ack"
+	//on the chunk or ack giving up the stream
+	HK_StreamAbort string = "abort"
+)
+
+const (
+	__StreamAckTimeout time.Duration = 5000
+)
+
+/*a reader getting no chunk and not read for so long gives up the stream*/
+var __StreamIdleTimeout time.Duration = 30000
+
+var (
+	ErrStreamAborted error = errors.New("Stream Aborted")
+	ErrStreamTimeout error = errors.New("Stream Timeout")
+	ErrStreamBroken  error = errors.New("Stream Chunk Lost Or Out Of Order")
+)
+
+/*
+split the readers into chunks sent one by one, and put them together on the far side,
+at most window chunks are sent before the far side acks them
+*/
+type Streamer interface {
+	Send(send func(msg Message) error, msg Message, reader io.Reader) error
+	Abort(id uint64)
+	Resolve(msg Message) (ok bool)
+	Open(msg Message) (io.ReadCloser, error)
+}
+
+/** ack sends the ack back to the node which sent the open message */
+func NewStreamer(chunkSize int, window int, ack func(ack Message, open Message) error) Streamer {
+	if window < 1 {
+		window = 1
+	}
+	return &streamer{
+		chunkSize: chunkSize,
+		window:    window,
+		ack:       ack,
+		readers:   make(map[uint64]*streamReader),
+		writers:   make(map[uint64]*streamWriter),
+	}
+}
+
+type streamer struct {
+	mutex     sync.Mutex
+	chunkSize int
+	window    int
+	ack       func(ack Message, open Message) error
+	readers   map[uint64]*streamReader
+	writers   map[uint64]*streamWriter
+}
+
+type streamWriter struct {
+	acks  chan int
+	abort chan bool
+}
+
+func streamInfo(info MessageInfo, id uint64, seq int) MessageInfo {
+	sinfo := NewMessageInfo()
+	action, state, _ := info.Info()
+	sinfo.SetAcion(action)
+	sinfo.SetState(state)
+	sinfo.SetTime(time.Now())
+	sinfo.SetHeader(HK_Stream, strconv.FormatUint(id, 10))
+	sinfo.SetHeader(HK_StreamSeq, strconv.Itoa(seq))
+	return sinfo
+}
+
+/** send the msg to open the stream, then the reader chunk by chunk until it ends */
+func (s *streamer) Send(send func(msg Message) error, msg Message, reader io.Reader) (err error) {
+	info := msg.GetInfo()
+	id := info.GetID()
+	writer := &streamWriter{
+		acks:  make(chan int, s.window),
+		abort: make(chan bool, 1),
+	}
+	s.mutex.Lock()
+	s.writers[id] = writer
+	s.mutex.Unlock()
+	defer func() {
+		s.mutex.Lock()
+		delete(s.writers, id)
+		s.mutex.Unlock()
+	}()
+
+	open := NewMessage(info.Copy())
+	open.SetContents(msg.GetContents())
+	open.GetInfo().SetHeader(HK_Stream, strconv.FormatUint(id, 10))
+	open.GetInfo().SetHeader(HK_StreamSeq, "0")
+	err = send(open)
+	if err != nil {
+		return
+	}
+	abortPeer := func(seq int) {
+		chunk := NewMessage(streamInfo(info, id, seq))
+		chunk.GetInfo().SetHeader(HK_StreamAbort, "")
+		send(chunk)
+	}
+	buf := make([]byte, s.chunkSize)
+	acked := 0
+	for seq := 1; ; seq++ {
+		n, rerr := io.ReadFull(reader, buf)
+		end := rerr == io.EOF || rerr == io.ErrUnexpectedEOF
+		if rerr != nil && !end {
+			abortPeer(seq)
+			return rerr
+		}
+		for seq-acked > s.window {
+			timer := time.NewTimer(__StreamAckTimeout * time.Millisecond)
+			select {
+			case ack := <-writer.acks:
+				if ack > acked {
+					acked = ack
+				}
+			case <-writer.abort:
+				err = ErrStreamAborted
+			case <-timer.C:
+				err = ErrStreamTimeout
+			}
+			timer.Stop()
+			if err != nil {
+				abortPeer(seq)
+				return
+			}
+		}
+		select {
+		case <-writer.abort:
+			abortPeer(seq)
+			return ErrStreamAborted
+		default:
+		}
+		chunk := NewMessage(streamInfo(info, id, seq))
+		chunk.AppendContent(append([]byte{}, buf[:n]...))
+		if end {
+			chunk.GetInfo().SetHeader(HK_StreamEnd, "")
+		}
+		err = send(chunk)
+		if err != nil || end {
+			return
+		}
+	}
+}
+
+/** give up the stream being sent */
+func (s *streamer) Abort(id uint64) {
+	s.mutex.Lock()
+	writer, ok := s.writers[id]
+	s.mutex.Unlock()
+	if ok {
+		writer.stop()
+	}
+}
+
+func (w *streamWriter) stop() {
+	select {
+	case w.abort <- true:
+	default:
+	}
+}
+
+/** take the chunks and the acks, false for the open message and the others */
+func (s *streamer) Resolve(msg Message) (ok bool) {
+	info := msg.GetInfo()
+	idstr, ok := info.GetHeader(HK_Stream)
+	if !ok {
+		return
+	}
+	id, err := strconv.ParseUint(idstr, 10, 64)
+	if err != nil {
+		return false
+	}
+	_, abort := info.GetHeader(HK_StreamAbort)
+	if ackstr, isack := info.GetHeader(HK_StreamAck); isack {
+		s.mutex.Lock()
+		writer, ok := s.writers[id]
+		s.mutex.Unlock()
+		if !ok {
+			return true
+		}
+		if abort {
+			writer.stop()
+			return true
+		}
+		ack, _ := strconv.Atoi(ackstr)
+		select {
+		case writer.acks <- ack:
+		default:
+		}
+		return true
+	}
+	seqstr, _ := info.GetHeader(HK_StreamSeq)
+	if seqstr == "0" {
+		//the open message is kept for the acks after the receiver releases it
+		reader := &streamReader{
+			streamer: s,
+			id:       id,
+			open:     msg.Retain(),
+			chunks:   make(chan []byte, s.window+1),
+			done:     make(chan bool),
+		}
+		//even the readers never opened are removed once idle
+		reader.idle = time.AfterFunc(__StreamIdleTimeout*time.Millisecond, reader.expire)
+		s.mutex.Lock()
+		s.readers[id] = reader
+		s.mutex.Unlock()
+		return false
+	}
+	seq, err := strconv.Atoi(seqstr)
+	s.mutex.Lock()
+	reader, ok := s.readers[id]
+	inOrder := ok && err == nil && seq == reader.last+1
+	if inOrder {
+		reader.last = seq
+	}
+	s.mutex.Unlock()
+	if !ok {
+		return true
+	}
+	if abort {
+		reader.fail(ErrStreamAborted)
+		return true
+	}
+	if !inOrder {
+		//a chunk lost, repeated or overtaken
+		reader.sendAck(0, true)
+		reader.fail(ErrStreamBroken)
+		return true
+	}
+	reader.touch()
+	//the chunk message is released by the receiver
+	content, _ := msg.GetContent(0)
+	select {
+	case reader.chunks <- append([]byte(nil), content...):
+	default:
+		//the sender did not wait for the acks
+		reader.sendAck(0, true)
+		reader.fail(ErrStreamAborted)
+		return true
+	}
+	if _, end := info.GetHeader(HK_StreamEnd); end {
+		//every chunk is here, nothing left to wait for
+		reader.idle.Stop()
+		close(reader.chunks)
+		s.remove(id)
+		reader.release()
+	}
+	return true
+}
+
+/** the reader of the stream opened by the msg */
+func (s *streamer) Open(msg Message) (io.ReadCloser, error) {
+	idstr, ok := msg.GetInfo().GetHeader(HK_Stream)
+	if !ok {
+		return nil, errors.New("Not A Stream")
+	}
+	id, err := strconv.ParseUint(idstr, 10, 64)
+	if err != nil {
+		return nil, err
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	reader, ok := s.readers[id]
+	if !ok {
+		return nil, errors.New("Stream " + idstr + " Not Exsit")
+	}
+	return reader, nil
+}
+
+func (s *streamer) remove(id uint64) {
+	s.mutex.Lock()
+	delete(s.readers, id)
+	s.mutex.Unlock()
+}
+
+type streamReader struct {
+	streamer *streamer
+	id       uint64
+	//the last chunk taken in order
+	last      int
+	openMutex sync.Mutex
+	//nil once released
+	open     Message
+	chunks   chan []byte
+	done     chan bool
+	idle     *time.Timer
+	once     sync.Once
+	err      error
+	current  []byte
+	consumed int
+	acked    int
+}
+
+func (r *streamReader) Read(p []byte) (n int, err error) {
+	select {
+	case <-r.done:
+		return 0, r.err
+	default:
+	}
+	r.touch()
+	for len(r.current) == 0 {
+		select {
+		case chunk, ok := <-r.chunks:
+			if !ok {
+				return 0, io.EOF
+			}
+			r.current = chunk
+			r.consumed++
+			if (r.consumed-r.acked)*2 >= r.streamer.window {
+				r.acked = r.consumed
+				r.sendAck(r.consumed, false)
+			}
+		case <-r.done:
+			return 0, r.err
+		}
+	}
+	n = copy(p, r.current)
+	r.current = r.current[n:]
+	return
+}
+
+/** closing before the end aborts the stream */
+func (r *streamReader) Close() error {
+	select {
+	case _, ok := <-r.chunks:
+		if !ok {
+			return nil
+		}
+	default:
+	}
+	r.sendAck(r.consumed, true)
+	r.fail(ErrStreamAborted)
+	return nil
+}
+
+func (r *streamReader) fail(err error) {
+	r.once.Do(func() {
+		r.err = err
+		r.idle.Stop()
+		close(r.done)
+		r.streamer.remove(r.id)
+		r.release()
+	})
+}
+
+/** the open message is not needed once the stream ended or failed */
+func (r *streamReader) release() {
+	r.openMutex.Lock()
+	defer r.openMutex.Unlock()
+	if r.open != nil {
+		r.open.Release()
+		r.open = nil
+	}
+}
+
+/** put off the idle timeout */
+func (r *streamReader) touch() {
+	r.idle.Reset(__StreamIdleTimeout * time.Millisecond)
+}
+
+/** nothing came and nothing was read for too long, the sender is told to give up */
+func (r *streamReader) expire() {
+	//ended or given up already
+	r.streamer.mutex.Lock()
+	_, ok := r.streamer.readers[r.id]
+	r.streamer.mutex.Unlock()
+	if !ok {
+		return
+	}
+	r.sendAck(0, true)
+	r.fail(ErrStreamTimeout)
+}
+
+/** nothing is sent once the open message is released */
+func (r *streamReader) sendAck(seq int, abort bool) {
+	r.openMutex.Lock()
+	if r.open == nil {
+		r.openMutex.Unlock()
+		return
+	}
+	info := streamInfo(r.open.GetInfo(), r.id, seq)
+	info.SetState(MS_Succeed)
+	info.SetHeader(HK_StreamAck, strconv.Itoa(seq))
+	if abort {
+		info.SetState(MS_Failed)
+		info.SetHeader(HK_StreamAbort, "")
+	}
+	err := r.streamer.ack(NewMessage(info), r.open)
+	r.openMutex.Unlock()
+	if err != nil {
+		r.fail(err)
+	}
+}
@@ -0,0 +1,173 @@
+package core
+
+import (
+	"bytes"
+	utils "github.com/gargous/flitter/common"
+	"io/ioutil"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func Test_Streamer(t *testing.T) {
+	t.Log(utils.Norf("Start Streamer"))
+	var sender, recver Streamer
+	sender = NewStreamer(1024, 4, nil)
+	recver = NewStreamer(1024, 4, func(ack Message, open Message) error {
+		if !sender.Resolve(ack) {
+			t.Error(utils.Errf("Ack not taken:%v", ack))
+		}
+		return nil
+	})
+	opens := make(chan Message, 2)
+	send := func(msg Message) error {
+		if !recver.Resolve(msg) {
+			opens <- msg
+		}
+		return nil
+	}
+
+	data := bytes.Repeat([]byte("flitter stream "), 10000)
+	info := NewMessageInfo()
+	info.SetAcion(MA_Refer)
+	msg := NewMessage(info)
+	msg.AppendContent([]byte("file"))
+	sent := make(chan error, 1)
+	go func() {
+		sent <- sender.Send(send, msg, bytes.NewReader(data))
+	}()
+	open := <-opens
+	if name, _ := open.GetContent(0); string(name) != "file" {
+		t.Fatal(utils.Errf("Wrong open message:%v", open))
+	}
+	reader, err := recver.Open(open)
+	if err != nil {
+		t.Fatal(utils.Errf("Open err:%v", err))
+	}
+	got, err := ioutil.ReadAll(reader)
+	if err != nil {
+		t.Fatal(utils.Errf("Read err:%v", err))
+	}
+	if !bytes.Equal(got, data) {
+		t.Fatal(utils.Errf("Stream changed, %d bytes from %d", len(got), len(data)))
+	}
+	if err = <-sent; err != nil {
+		t.Fatal(utils.Errf("Send err:%v", err))
+	}
+
+	go func() {
+		sent <- sender.Send(send, NewMessage(NewMessageInfo()), bytes.NewReader(data))
+	}()
+	open = <-opens
+	reader, err = recver.Open(open)
+	if err != nil {
+		t.Fatal(utils.Errf("Open err:%v", err))
+	}
+	buf := make([]byte, 100)
+	reader.Read(buf)
+	reader.Close()
+	if err = <-sent; err != ErrStreamAborted {
+		t.Fatal(utils.Errf("Should abort but:%v", err))
+	}
+	if _, err = reader.Read(buf); err != ErrStreamAborted {
+		t.Fatal(utils.Errf("Read after abort:%v", err))
+	}
+	t.Log(utils.Norf("End Streamer"))
+}
+
+func Test_Streamer_Idle(t *testing.T) {
+	t.Log(utils.Norf("Start Streamer Idle"))
+	timeout := __StreamIdleTimeout
+	__StreamIdleTimeout = 20
+	defer func() { __StreamIdleTimeout = timeout }()
+	aborted := make(chan Message, 2)
+	recver := NewStreamer(1024, 4, func(ack Message, open Message) error {
+		aborted <- ack
+		return nil
+	}).(*streamer)
+	newOpen := func() Message {
+		info := NewMessageInfo()
+		info.SetHeader(HK_Stream, strconv.FormatUint(info.GetID(), 10))
+		info.SetHeader(HK_StreamSeq, "0")
+		return NewMessage(info)
+	}
+
+	//never opened
+	recver.Resolve(newOpen())
+	//opened, but the sender stops sending
+	open := newOpen()
+	recver.Resolve(open)
+	reader, err := recver.Open(open)
+	if err != nil {
+		t.Fatal(utils.Errf("Open err:%v", err))
+	}
+	if _, err = reader.Read(make([]byte, 10)); err != ErrStreamTimeout {
+		t.Fatal(utils.Errf("Read should time out but:%v", err))
+	}
+	for i := 0; i < 2; i++ {
+		select {
+		case ack := <-aborted:
+			if _, ok := ack.GetInfo().GetHeader(HK_StreamAbort); !ok {
+				t.Fatal(utils.Errf("Not an abort:%v", ack))
+			}
+		case <-time.After(time.Second):
+			t.Fatal(utils.Errf("Sender not told to give up"))
+		}
+	}
+	recver.mutex.Lock()
+	left := len(recver.readers)
+	recver.mutex.Unlock()
+	if left != 0 {
+		t.Fatal(utils.Errf("%d idle readers not removed", left))
+	}
+	t.Log(utils.Norf("End Streamer Idle"))
+}
+
+func Test_Streamer_Order(t *testing.T) {
+	t.Log(utils.Norf("Start Streamer Order"))
+	acks := make(chan Message, 4)
+	recver := NewStreamer(1024, 4, func(ack Message, open Message) error {
+		if _, ok := open.GetInfo().GetHeader(HK_Stream); !ok {
+			t.Error(utils.Errf("Open message lost:%v", open))
+		}
+		acks <- ack
+		return nil
+	})
+	info := NewMessageInfo()
+	id := strconv.FormatUint(info.GetID(), 10)
+	info.SetHeader(HK_Stream, id)
+	info.SetHeader(HK_StreamSeq, "0")
+	//received into the pool, and released by the receiver at once
+	open := newPooledMessage()
+	open.info = info
+	if recver.Resolve(open) {
+		t.Fatal(utils.Errf("Open message taken"))
+	}
+	reader, err := recver.Open(open)
+	if err != nil {
+		t.Fatal(utils.Errf("Open err:%v", err))
+	}
+	open.Release()
+	for _, seq := range []string{"1", "3"} {
+		cinfo := NewMessageInfo()
+		cinfo.SetHeader(HK_Stream, id)
+		cinfo.SetHeader(HK_StreamSeq, seq)
+		chunk := NewMessage(cinfo)
+		chunk.AppendContent([]byte(seq))
+		if !recver.Resolve(chunk) {
+			t.Fatal(utils.Errf("Chunk not taken:%v", chunk))
+		}
+	}
+	select {
+	case ack := <-acks:
+		if _, ok := ack.GetInfo().GetHeader(HK_StreamAbort); !ok {
+			t.Fatal(utils.Errf("Not an abort:%v", ack))
+		}
+	default:
+		t.Fatal(utils.Errf("The chunk out of order not refused"))
+	}
+	if _, err = reader.Read(make([]byte, 10)); err != ErrStreamBroken {
+		t.Fatal(utils.Errf("Read a broken stream:%v", err))
+	}
+	t.Log(utils.Norf("End Streamer Order"))
+}
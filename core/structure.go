@@ -31,6 +31,17 @@ func (s *Serializer) Encode(seris Serializable) (int, []byte, error) {
 	size, err := seris.Read(buf)
 	return size, buf, err
 }
+
+/** like Encode, into a buffer taken from the pool, Free it when the bytes are sent */
+func (s *Serializer) EncodePooled(seris Serializable) (int, *Buffer, error) {
+	buffer := GetBuffer(seris.Size())
+	size, err := seris.Read(buffer.B)
+	if err != nil {
+		buffer.Free()
+		return 0, nil, err
+	}
+	return size, buffer, nil
+}
 func (s *Serializer) Decode(seris Serializable, buf []byte) (int, error) {
 	size, err := seris.Write(buf)
 	if err != nil {
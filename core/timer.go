@@ -0,0 +1,316 @@
+package core
+
+import (
+	"errors"
+	"math/rand"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*a handle of the timers of a looper, they all stop when the looper terms*/
+type Timer interface {
+	//false if it was stopped or done already
+	Stop() bool
+	//fire after delay milliseconds, every delay for the intervals, false for the crons
+	Reset(delay time.Duration) bool
+	//zero once stopped
+	Next() time.Time
+}
+
+type looperTimer struct {
+	looper   *messageLooper
+	handler  func(t time.Time) error
+	repeat   bool
+	interval time.Duration
+	jitter   float64
+	cron     *CronSpec
+	mutex    sync.Mutex
+	timer    ClockTimer
+	next     time.Time
+	stopped  bool
+	//bumped by Reset, so a run already begun does not arm the timer again
+	generation int
+}
+
+/** run the handler once after delay milliseconds */
+func (m *messageLooper) After(delay time.Duration, handler func(t time.Time) error) Timer {
+	t := &looperTimer{looper: m, handler: handler}
+	m.startTimer(t, m.clock.Now().Add(delay*time.Millisecond))
+	return t
+}
+
+/** run the handler every interval milliseconds, give or take the jitter part of it */
+func (m *messageLooper) Every(interval time.Duration, jitter float64, handler func(t time.Time) error) Timer {
+	t := &looperTimer{looper: m, handler: handler, repeat: true, interval: interval, jitter: jitter}
+	m.startTimer(t, t.following(m.clock.Now()))
+	return t
+}
+
+/** run the handler at the times matching the five fields of spec, like "*\/5 9-17 * * 1-5" */
+func (m *messageLooper) Cron(spec string, handler func(t time.Time) error) (Timer, error) {
+	cron, err := ParseCron(spec)
+	if err != nil {
+		return nil, err
+	}
+	t := &looperTimer{looper: m, handler: handler, repeat: true, cron: cron}
+	m.startTimer(t, cron.Next(m.clock.Now()))
+	return t, nil
+}
+
+/** the same as Every without jitter */
+func (m *messageLooper) SetInterval(timestamp time.Duration, handler func(t time.Time) error) Timer {
+	return m.Every(timestamp, 0, handler)
+}
+
+func (m *messageLooper) startTimer(t *looperTimer, at time.Time) {
+	m.timerMutex.Lock()
+	defer m.timerMutex.Unlock()
+	select {
+	case <-m.done:
+		t.stopped = true
+		return
+	default:
+	}
+	m.timers[t] = true
+	t.mutex.Lock()
+	t.arm(at)
+	t.mutex.Unlock()
+}
+
+func (m *messageLooper) forgetTimer(t *looperTimer) {
+	m.timerMutex.Lock()
+	delete(m.timers, t)
+	m.timerMutex.Unlock()
+}
+
+func (m *messageLooper) stopTimers() {
+	m.timerMutex.Lock()
+	timers := m.timers
+	m.timers = make(map[*looperTimer]bool)
+	m.timerMutex.Unlock()
+	for t := range timers {
+		t.Stop()
+	}
+}
+
+/** with the mutex held */
+func (t *looperTimer) arm(at time.Time) {
+	if t.timer != nil {
+		t.timer.Stop()
+	}
+	if at.IsZero() {
+		t.stopped = true
+		t.next = at
+		return
+	}
+	t.next = at
+	generation := t.generation
+	t.timer = t.looper.clock.AfterFunc(at.Sub(t.looper.clock.Now()), func() {
+		t.fire(generation)
+	})
+}
+
+func (t *looperTimer) fire(generation int) {
+	t.mutex.Lock()
+	if t.stopped || generation != t.generation {
+		t.mutex.Unlock()
+		return
+	}
+	scheduled := t.next
+	t.mutex.Unlock()
+
+	t.looper.gatherError(func(msg Message) error {
+		return t.handler(t.looper.clock.Now())
+	}, nil)
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if t.stopped || generation != t.generation {
+		return
+	}
+	if !t.repeat {
+		t.stopped = true
+		t.next = time.Time{}
+		t.looper.forgetTimer(t)
+		return
+	}
+	//the runs missed while handling are skipped
+	next := t.following(scheduled)
+	if now := t.looper.clock.Now(); next.Before(now) {
+		next = t.following(now)
+	}
+	t.arm(next)
+}
+
+func (t *looperTimer) following(from time.Time) time.Time {
+	if t.cron != nil {
+		return t.cron.Next(from)
+	}
+	interval := t.interval * time.Millisecond
+	if t.jitter > 0 {
+		interval += time.Duration(float64(interval) * t.jitter * (rand.Float64()*2 - 1))
+	}
+	if interval <= 0 {
+		interval = time.Millisecond
+	}
+	return from.Add(interval)
+}
+
+func (t *looperTimer) Stop() bool {
+	t.mutex.Lock()
+	if t.stopped {
+		t.mutex.Unlock()
+		return false
+	}
+	t.stopped = true
+	t.next = time.Time{}
+	t.generation++
+	if t.timer != nil {
+		t.timer.Stop()
+	}
+	t.mutex.Unlock()
+	t.looper.forgetTimer(t)
+	return true
+}
+
+func (t *looperTimer) Reset(delay time.Duration) bool {
+	if t.cron != nil {
+		return false
+	}
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if t.stopped {
+		return false
+	}
+	t.generation++
+	if t.repeat {
+		t.interval = delay
+		t.arm(t.following(t.looper.clock.Now()))
+		return true
+	}
+	t.arm(t.looper.clock.Now().Add(delay * time.Millisecond))
+	return true
+}
+
+func (t *looperTimer) Next() time.Time {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	return t.next
+}
+
+/*minute hour day-of-month month day-of-week, every field a list of *, n, a-b with an optional /step*/
+type CronSpec struct {
+	minute uint64
+	hour   uint64
+	dom    uint64
+	month  uint64
+	dow    uint64
+	//the day matches either day field when both are given
+	anyDom bool
+	anyDow bool
+}
+
+var __cronBounds = [5][2]int{{0, 59}, {0, 23}, {1, 31}, {1, 12}, {0, 7}}
+
+func ParseCron(spec string) (cron *CronSpec, err error) {
+	fields := strings.Fields(spec)
+	if len(fields) != 5 {
+		err = errors.New("Cron Needs Five Fields")
+		return
+	}
+	bits := make([]uint64, 5)
+	for index, field := range fields {
+		bits[index], err = parseCronField(field, __cronBounds[index][0], __cronBounds[index][1])
+		if err != nil {
+			return
+		}
+	}
+	//sunday is 0 or 7
+	if bits[4]&(1<<7) != 0 {
+		bits[4] |= 1
+	}
+	cron = &CronSpec{
+		minute: bits[0],
+		hour:   bits[1],
+		dom:    bits[2],
+		month:  bits[3],
+		dow:    bits[4],
+		anyDom: fields[2] == "*",
+		anyDow: fields[4] == "*",
+	}
+	return
+}
+
+func parseCronField(field string, min, max int) (bits uint64, err error) {
+	for _, part := range strings.Split(field, ",") {
+		step := 1
+		if index := strings.Index(part, "/"); index >= 0 {
+			step, err = strconv.Atoi(part[index+1:])
+			if err != nil || step <= 0 {
+				err = errors.New("Invalid Cron Step " + part)
+				return
+			}
+			part = part[:index]
+		}
+		low, high := min, max
+		if part != "*" {
+			bounds := strings.SplitN(part, "-", 2)
+			low, err = strconv.Atoi(bounds[0])
+			if err != nil {
+				err = errors.New("Invalid Cron Value " + part)
+				return
+			}
+			high = low
+			if len(bounds) > 1 {
+				high, err = strconv.Atoi(bounds[1])
+				if err != nil {
+					err = errors.New("Invalid Cron Value " + part)
+					return
+				}
+			} else if step > 1 {
+				high = max
+			}
+		}
+		if low < min || high > max || low > high {
+			err = errors.New("Cron Value Out Of Range " + part)
+			return
+		}
+		for value := low; value <= high; value += step {
+			bits |= 1 << uint(value)
+		}
+	}
+	return
+}
+
+func (c *CronSpec) dayMatches(t time.Time) bool {
+	dom := c.dom&(1<<uint(t.Day())) != 0
+	dow := c.dow&(1<<uint(t.Weekday())) != 0
+	if c.anyDom || c.anyDow {
+		return dom && dow
+	}
+	return dom || dow
+}
+
+/** the first matching minute after from, zero if none in five years */
+func (c *CronSpec) Next(from time.Time) time.Time {
+	t := from.Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(5, 0, 0)
+	for t.Before(limit) {
+		year, month, day := t.Date()
+		switch {
+		case c.month&(1<<uint(month)) == 0:
+			t = time.Date(year, month+1, 1, 0, 0, 0, 0, t.Location())
+		case !c.dayMatches(t):
+			t = time.Date(year, month, day+1, 0, 0, 0, 0, t.Location())
+		case c.hour&(1<<uint(t.Hour())) == 0:
+			t = time.Date(year, month, day, t.Hour()+1, 0, 0, 0, t.Location())
+		case c.minute&(1<<uint(t.Minute())) == 0:
+			t = t.Add(time.Minute)
+		default:
+			return t
+		}
+	}
+	return time.Time{}
+}
@@ -0,0 +1,77 @@
+package core
+
+import (
+	utils "github.com/gargous/flitter/common"
+	"testing"
+	"time"
+)
+
+func Test_Cron(t *testing.T) {
+	t.Log(utils.Norf("Start Cron"))
+	from := time.Date(2017, 3, 10, 8, 59, 30, 0, time.UTC)
+	cases := map[string]time.Time{
+		"* * * * *":       time.Date(2017, 3, 10, 9, 0, 0, 0, time.UTC),
+		"*/15 9-17 * * *": time.Date(2017, 3, 10, 9, 0, 0, 0, time.UTC),
+		"30 8 * * *":      time.Date(2017, 3, 11, 8, 30, 0, 0, time.UTC),
+		"0 0 1 * *":       time.Date(2017, 4, 1, 0, 0, 0, 0, time.UTC),
+		"0 12 * * 0":      time.Date(2017, 3, 12, 12, 0, 0, 0, time.UTC),
+		"0 12 * * 7":      time.Date(2017, 3, 12, 12, 0, 0, 0, time.UTC),
+		"0 0 13 * 1":      time.Date(2017, 3, 13, 0, 0, 0, 0, time.UTC),
+		"5,10 10 * 2 *":   time.Date(2018, 2, 1, 10, 5, 0, 0, time.UTC),
+	}
+	for spec, want := range cases {
+		cron, err := ParseCron(spec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := cron.Next(from); !got.Equal(want) {
+			t.Fatal(utils.Errf("%s next to %v is %v, want %v", spec, from, got, want))
+		}
+	}
+	for _, spec := range []string{"* * * *", "60 * * * *", "a * * * *", "*/0 * * * *", "5-1 * * * *"} {
+		if _, err := ParseCron(spec); err == nil {
+			t.Fatal(utils.Errf("%s parsed", spec))
+		}
+	}
+	t.Log(utils.Norf("End Cron"))
+}
+
+func Test_Timer(t *testing.T) {
+	t.Log(utils.Norf("Start Timer"))
+	looper := NewMessageLooper(10)
+	looper.AddHandler(0, MA_Update, func(msg Message) error { return nil })
+	go looper.Loop()
+	once := make(chan bool, 2)
+	looper.After(10, func(t time.Time) error {
+		once <- true
+		return nil
+	})
+	ticks := make(chan bool, 100)
+	every := looper.Every(10, 0.5, func(t time.Time) error {
+		ticks <- true
+		return nil
+	})
+	stopped := looper.After(10, func(t time.Time) error {
+		once <- false
+		return nil
+	})
+	if !stopped.Stop() || stopped.Stop() {
+		t.Fatal(utils.Errf("Stop twice"))
+	}
+	time.Sleep(100 * time.Millisecond)
+	if len(once) != 1 || !<-once {
+		t.Fatal(utils.Errf("One shot fired %d times", len(once)))
+	}
+	if len(ticks) < 3 {
+		t.Fatal(utils.Errf("Ticked %d times", len(ticks)))
+	}
+	if !every.Reset(1000) || every.Next().Before(time.Now().Add(400*time.Millisecond)) {
+		t.Fatal(utils.Errf("Not reset:%v", every.Next()))
+	}
+	looper.Term()
+	time.Sleep(20 * time.Millisecond)
+	if !every.Next().IsZero() {
+		t.Fatal(utils.Errf("Not stopped by term"))
+	}
+	t.Log(utils.Norf("End Timer"))
+}
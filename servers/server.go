@@ -7,12 +7,15 @@ import (
 	"github.com/gargous/flitter/core"
 	socketio "github.com/googollee/go-socket.io"
 	"net/http"
+	"time"
 )
 
 type baseServer struct {
 	common.BaseDataSet
 	serverPath     core.NodePath
 	srvices        map[ServiceType]Service
+	requests       core.Requester
+	supervisor     Supervisor
 	clientSrv      *socketio.Server
 	clientSessions map[string]socketio.Socket
 	clientHandlers map[string](func(so socketio.Socket) interface{})
@@ -27,6 +30,13 @@ type Server interface {
 	ConfigService(st ServiceType, srvice Service)
 	SendService(st ServiceType, msg core.Message) error
 	GetClientSocket() *socketio.Server
+	SetCompression(threshold int)
+	SetSecret(secret []byte)
+	SetRecorder(recorder core.Recorder)
+	SetRestart(policy RestartPolicy)
+	Shutdown(timeout time.Duration) error
+	Healthy() bool
+	Stats() map[ServiceType]core.LooperStats
 }
 
 func (b *baseServer) SetPath(path core.NodePath) {
@@ -35,6 +45,45 @@ func (b *baseServer) SetPath(path core.NodePath) {
 func (b baseServer) GetPath() (path core.NodePath) {
 	return b.serverPath
 }
+
+/** the services started after it are supervised by the policy */
+func (b *baseServer) SetRestart(policy RestartPolicy) {
+	b.supervisor = NewSupervisor(policy)
+}
+
+/** drain every service at once, the first error of them if any, timeout in milliseconds */
+func (b *baseServer) Shutdown(timeout time.Duration) (err error) {
+	errs := make(chan error, len(b.srvices))
+	for st, srvice := range b.srvices {
+		go func(st ServiceType, srvice Service) {
+			err := srvice.Drain(timeout)
+			if err != nil {
+				err = common.ErrAppend(err, "Drain "+st.String())
+			}
+			errs <- err
+		}(st, srvice)
+	}
+	for range b.srvices {
+		if derr := <-errs; derr != nil && err == nil {
+			err = derr
+		}
+	}
+	return
+}
+
+/** false once a service crashed more than its restart policy allows */
+func (b *baseServer) Healthy() bool {
+	return b.supervisor.Healthy()
+}
+
+/** a snapshot of the looper of every service */
+func (b *baseServer) Stats() map[ServiceType]core.LooperStats {
+	stats := make(map[ServiceType]core.LooperStats, len(b.srvices))
+	for st, srvice := range b.srvices {
+		stats[st] = srvice.Stats()
+	}
+	return stats
+}
 func (b *baseServer) GetClientSocket() *socketio.Server {
 	return b.clientSrv
 }
@@ -117,6 +166,29 @@ func (b *baseServer) InitClientHandler(cb func()) (err error) {
 func (b *baseServer) ConfigService(st ServiceType, srvice Service) {
 	b.srvices[st] = srvice
 }
+
+/** every service but the first gets a copy, since the handlers change the messages they handle */
+func (b *baseServer) dispatch(msg core.Message) {
+	defer msg.Release()
+	if b.requests != nil && b.requests.Resolve(msg) {
+		return
+	}
+	target, ok := msg.GetInfo().GetHeader(core.HK_Service)
+	first := true
+	for st, srvice := range b.srvices {
+		if ok && target != st.String() {
+			continue
+		}
+		if first {
+			srvice.Push(msg)
+			first = false
+			continue
+		}
+		copied := msg.Copy()
+		srvice.Push(copied)
+		copied.Release()
+	}
+}
 func (b *baseServer) SendService(st ServiceType, msg core.Message) (err error) {
 	srvice, ok := b.srvices[st]
 	if ok {
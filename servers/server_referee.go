@@ -4,10 +4,12 @@ import (
 	"github.com/gargous/flitter/common"
 	"github.com/gargous/flitter/core"
 	"sync"
+	"time"
 )
 
 type Referee interface {
 	SendToWroker(msg core.Message, npath core.NodePath) error
+	Request(msg core.Message, npath core.NodePath, timeout time.Duration) (core.Message, error)
 	Server
 }
 type refereesrv struct {
@@ -27,6 +29,8 @@ func NewReferee(npath core.NodePath) (referee Referee, err error) {
 	}
 	_referee.SetPath(npath)
 	_referee.srvices = make(map[ServiceType]Service)
+	_referee.requests = core.NewRequester()
+	_referee.supervisor = NewSupervisor(__DefaultRestart)
 	info, err := _ParseAddress(npath, SRT_Worker, SRT_Referee)
 	if err != nil {
 		return
@@ -36,11 +40,19 @@ func NewReferee(npath core.NodePath) (referee Referee, err error) {
 		return
 	}
 	_referee.recverW2R = recverW2R
+	recverW2R.SetMismatchReply(_referee.SendToWroker)
+	if len(__clusterSecret) > 0 {
+		_referee.SetSecret(__clusterSecret)
+	}
+	if __recorder != nil {
+		_referee.SetRecorder(__recorder)
+	}
 	referee = _referee
 	return
 }
 
 func (r *refereesrv) SendToWroker(msg core.Message, npath core.NodePath) (err error) {
+	msg.GetInfo().SetHeader(core.HK_Source, string(r.GetPath()))
 	info, err := _ParseAddress(npath, SRT_Referee, SRT_Worker)
 	if err != nil {
 		return
@@ -52,6 +64,27 @@ func (r *refereesrv) SendToWroker(msg core.Message, npath core.NodePath) (err er
 	return
 }
 
+/** the reply is retained for the caller, who releases it */
+func (r *refereesrv) Request(msg core.Message, npath core.NodePath, timeout time.Duration) (core.Message, error) {
+	return r.requests.Request(func(msg core.Message) error {
+		return r.SendToWroker(msg, npath)
+	}, msg, timeout)
+}
+
+func (r *refereesrv) SetCompression(threshold int) {
+	r.senderR2W.SetCompression(threshold)
+}
+
+func (r *refereesrv) SetSecret(secret []byte) {
+	r.recverW2R.SetSecret(secret)
+	r.senderR2W.SetSecret(secret)
+}
+
+func (r *refereesrv) SetRecorder(recorder core.Recorder) {
+	r.recverW2R.SetRecorder(recorder)
+	r.senderR2W.SetRecorder(recorder)
+}
+
 func (r *refereesrv) Start() (err error) {
 	err = r.recverW2R.Bind()
 	if err != nil {
@@ -65,16 +98,14 @@ func (r *refereesrv) Start() (err error) {
 				continue
 			}
 			if msg != nil {
-				for _, srvice := range r.srvices {
-					srvice.Push(msg)
-				}
+				r.dispatch(msg)
 			}
 		}
 	}()
 	r.wg.Add(len(r.srvices))
 	index := 0
-	for _, srvice := range r.srvices {
-		func(srvice Service) {
+	for st, srvice := range r.srvices {
+		func(st ServiceType, srvice Service) {
 			go func() {
 				defer r.wg.Done()
 				err = srvice.Init(r)
@@ -87,9 +118,9 @@ func (r *refereesrv) Start() (err error) {
 					dpath := r.GetPath()
 					common.Logf(common.Norf, "Referee Started At %v\n%v", dpath, r)
 				}
-				srvice.Start()
+				r.supervisor.Supervise(st, srvice)
 			}()
-		}(srvice)
+		}(st, srvice)
 	}
 	if err != nil {
 		return
@@ -3,18 +3,30 @@ package servers
 import (
 	"github.com/gargous/flitter/common"
 	"github.com/gargous/flitter/core"
+	"io"
 	"sync"
+	"time"
 )
 
 type Worker interface {
 	SendToReferee(msg core.Message, npath core.NodePath) error
 	SendToWroker(msg core.Message, npath core.NodePath) error
+	Request(msg core.Message, npath core.NodePath, timeout time.Duration) (core.Message, error)
+	RequestReferee(msg core.Message, npath core.NodePath, timeout time.Duration) (core.Message, error)
+	SendStream(msg core.Message, npath core.NodePath, reader io.Reader) error
+	OpenStream(msg core.Message) (io.ReadCloser, error)
+	AbortStream(msg core.Message)
 	PublishToWorker(msg core.Message) error
 	SubscribeWorker(npath core.NodePath) error
 	Server
 	common.DataSet
 }
 
+const (
+	__StreamChunkSize int = 64 * 1024
+	__StreamWindow    int = 8
+)
+
 type workersrv struct {
 	recverR2W  core.Receiver
 	senderW2R  core.Sender
@@ -22,8 +34,14 @@ type workersrv struct {
 	senderW2W  core.Sender
 	subscriber core.Subscriber
 	publisher  core.Publisher
+	streams    core.Streamer
 	wg         sync.WaitGroup
 	mutex      sync.Mutex
+
+	//given to the senders made for the streams
+	compression int
+	secret      []byte
+	recorder    core.Recorder
 	baseServer
 }
 
@@ -47,6 +65,8 @@ func NewWorker(npath core.NodePath) (worker Worker, err error) {
 	}
 	_worker.SetPath(npath)
 	_worker.srvices = make(map[ServiceType]Service)
+	_worker.requests = core.NewRequester()
+	_worker.supervisor = NewSupervisor(__DefaultRestart)
 
 	recverR2WAddr, err := _ParseAddress(npath, SRT_Referee, SRT_Worker)
 	if err != nil {
@@ -78,6 +98,20 @@ func NewWorker(npath core.NodePath) (worker Worker, err error) {
 	_worker.recverR2W = recverR2W
 	_worker.recverW2W = recverW2W
 	_worker.publisher = publisher
+	_worker.streams = core.NewStreamer(__StreamChunkSize, __StreamWindow, func(ack core.Message, open core.Message) error {
+		source, _ := open.GetInfo().GetHeader(core.HK_Source)
+		return _worker.SendToWroker(ack, core.NodePath(source))
+	})
+	recverW2W.SetStreamer(_worker.streams)
+	recverR2W.SetMismatchReply(_worker.SendToReferee)
+	recverW2W.SetMismatchReply(_worker.SendToWroker)
+	subscriber.SetMismatchReply(_worker.SendToWroker)
+	if len(__clusterSecret) > 0 {
+		_worker.SetSecret(__clusterSecret)
+	}
+	if __recorder != nil {
+		_worker.SetRecorder(__recorder)
+	}
 	worker = _worker
 	return
 }
@@ -85,6 +119,7 @@ func NewWorker(npath core.NodePath) (worker Worker, err error) {
 func (w *workersrv) SendToReferee(msg core.Message, npath core.NodePath) (err error) {
 	w.mutex.Lock()
 	defer w.mutex.Unlock()
+	msg.GetInfo().SetHeader(core.HK_Source, string(w.GetPath()))
 	info, err := _ParseAddress(npath, SRT_Worker, SRT_Referee)
 	if err != nil {
 		return
@@ -98,6 +133,7 @@ func (w *workersrv) SendToReferee(msg core.Message, npath core.NodePath) (err er
 func (w *workersrv) SendToWroker(msg core.Message, npath core.NodePath) (err error) {
 	w.mutex.Lock()
 	defer w.mutex.Unlock()
+	msg.GetInfo().SetHeader(core.HK_Source, string(w.GetPath()))
 	info, err := _ParseAddress(npath, SRT_Worker, SRT_Worker)
 	if err != nil {
 		return
@@ -111,7 +147,53 @@ func (w *workersrv) SendToWroker(msg core.Message, npath core.NodePath) (err err
 	err = w.senderW2W.Send(msg)
 	return
 }
+
+/** the reply is retained for the caller, who releases it */
+func (w *workersrv) Request(msg core.Message, npath core.NodePath, timeout time.Duration) (core.Message, error) {
+	return w.requests.Request(func(msg core.Message) error {
+		return w.SendToWroker(msg, npath)
+	}, msg, timeout)
+}
+func (w *workersrv) RequestReferee(msg core.Message, npath core.NodePath, timeout time.Duration) (core.Message, error) {
+	return w.requests.Request(func(msg core.Message) error {
+		return w.SendToReferee(msg, npath)
+	}, msg, timeout)
+}
+
+/** the worker at npath gets the msg and reads the rest with OpenStream, the acks come back to the recverW2W, the stream keeps a connection of its own until it ends */
+func (w *workersrv) SendStream(msg core.Message, npath core.NodePath, reader io.Reader) (err error) {
+	info, err := _ParseAddress(npath, SRT_Worker, SRT_Worker)
+	if err != nil {
+		return
+	}
+	sender, err := core.NewSender()
+	if err != nil {
+		return
+	}
+	defer sender.Close()
+	w.mutex.Lock()
+	sender.SetCompression(w.compression)
+	sender.SetSecret(w.secret)
+	sender.SetRecorder(w.recorder)
+	w.mutex.Unlock()
+	sender.SetStreamer(w.streams)
+	sender.AddNodeInfo(info)
+	err = sender.Connect()
+	if err != nil {
+		return
+	}
+	//the acks go back to the source of the open message
+	msg.GetInfo().SetHeader(core.HK_Source, string(w.GetPath()))
+	return sender.SendStream(msg, reader)
+}
+func (w *workersrv) OpenStream(msg core.Message) (io.ReadCloser, error) {
+	return w.streams.Open(msg)
+}
+func (w *workersrv) AbortStream(msg core.Message) {
+	w.streams.Abort(msg.GetInfo().GetID())
+}
 func (w *workersrv) PublishToWorker(msg core.Message) error {
+	msg.GetInfo().SetHeader(core.HK_Source, string(w.GetPath()))
 	return w.publisher.Send(msg)
 }
 func (w *workersrv) SubscribeWorker(npath core.NodePath) (err error) {
@@ -126,6 +208,36 @@ func (w *workersrv) SubscribeWorker(npath core.NodePath) (err error) {
 	err = w.subscriber.Connect()
 	return
 }
+func (w *workersrv) SetCompression(threshold int) {
+	w.mutex.Lock()
+	w.compression = threshold
+	w.mutex.Unlock()
+	w.senderW2R.SetCompression(threshold)
+	w.senderW2W.SetCompression(threshold)
+	w.publisher.SetCompression(threshold)
+}
+func (w *workersrv) SetSecret(secret []byte) {
+	w.mutex.Lock()
+	w.secret = secret
+	w.mutex.Unlock()
+	w.recverR2W.SetSecret(secret)
+	w.senderW2R.SetSecret(secret)
+	w.recverW2W.SetSecret(secret)
+	w.senderW2W.SetSecret(secret)
+	w.subscriber.SetSecret(secret)
+	w.publisher.SetSecret(secret)
+}
+func (w *workersrv) SetRecorder(recorder core.Recorder) {
+	w.mutex.Lock()
+	w.recorder = recorder
+	w.mutex.Unlock()
+	w.recverR2W.SetRecorder(recorder)
+	w.senderW2R.SetRecorder(recorder)
+	w.recverW2W.SetRecorder(recorder)
+	w.senderW2W.SetRecorder(recorder)
+	w.subscriber.SetRecorder(recorder)
+	w.publisher.SetRecorder(recorder)
+}
 func (w *workersrv) Start() (err error) {
 	err = w.recverR2W.Bind()
 	if err != nil {
@@ -147,9 +259,7 @@ func (w *workersrv) Start() (err error) {
 				continue
 			}
 			if msg != nil {
-				for _, srvice := range w.srvices {
-					srvice.Push(msg)
-				}
+				w.dispatch(msg)
 			}
 		}
 	}()
@@ -161,9 +271,7 @@ func (w *workersrv) Start() (err error) {
 				continue
 			}
 			if msg != nil {
-				for _, srvice := range w.srvices {
-					srvice.Push(msg)
-				}
+				w.dispatch(msg)
 			}
 		}
 	}()
@@ -175,16 +283,14 @@ func (w *workersrv) Start() (err error) {
 				continue
 			}
 			if msg != nil {
-				for _, srvice := range w.srvices {
-					srvice.Push(msg)
-				}
+				w.dispatch(msg)
 			}
 		}
 	}()
 	w.wg.Add(len(w.srvices))
 	index := 0
-	for _, srvice := range w.srvices {
-		func(srvice Service) {
+	for st, srvice := range w.srvices {
+		func(st ServiceType, srvice Service) {
 			go func() {
 				defer w.wg.Done()
 				err = srvice.Init(w)
@@ -196,9 +302,9 @@ func (w *workersrv) Start() (err error) {
 				if index >= len(w.srvices) {
 					common.Logf(common.Norf, "Worker Started At %v\n%v", w.GetPath(), w)
 				}
-				srvice.Start()
+				w.supervisor.Supervise(st, srvice)
 			}()
-		}(srvice)
+		}(st, srvice)
 	}
 	w.subscriber.SetSubscribe("")
 	err = w.InitClientHandler(nil)
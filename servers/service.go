@@ -4,15 +4,23 @@ import (
 	"flag"
 	common "github.com/gargous/flitter/common"
 	core "github.com/gargous/flitter/core"
+	"time"
 )
 
 const __LooperSize int = 10
 
+/*a full service queue refuses after a while, rather than stall the goroutines receiving for every service, the retries and errors of the looper itself still get in*/
+var __LooperBackpressure core.Backpressure = core.Backpressure{Policy: core.OP_Block, Timeout: 1000}
+
 type Service interface {
 	Init(srv interface{}) error
 	Start()
 	Term()
+	Drain(timeout time.Duration) error
 	Push(msg core.Message)
+	DeadLetters() core.DeadLetters
+	SetClock(clock core.Clock)
+	Stats() core.LooperStats
 	String() string
 }
 
@@ -28,15 +36,58 @@ func (s *baseService) Push(msg core.Message) {
 	}
 }
 
+/** the timers and retries of the service go by the clock, before Init */
+func (s *baseService) SetClock(clock core.Clock) {
+	s.looper.SetClock(clock)
+}
+
+func (s *baseService) Stats() core.LooperStats {
+	return s.looper.Stats()
+}
+
+/** handle the messages queued and stop, Start returns once drained, timeout in milliseconds */
+func (s *baseService) Drain(timeout time.Duration) error {
+	return s.looper.Drain(timeout)
+}
+
+/** the messages the service gave up retrying */
+func (s *baseService) DeadLetters() core.DeadLetters {
+	return s.looper.DeadLetters()
+}
+
 var __lauched bool = false
 
+/*shared by every node of the cluster, the servers made after setting it sign their messages*/
+var __clusterSecret []byte
+
+func SetClusterSecret(secret []byte) {
+	__clusterSecret = secret
+}
+
+/*every server made after setting it records the messages it passes*/
+var __recorder core.Recorder
+
+func SetRecorder(recorder core.Recorder) {
+	__recorder = recorder
+}
+
 func Lauch() {
 	if !__lauched {
 		__lauched = true
 		verb := flag.Bool("v", false, "verbs")
 		filename := flag.String("log", "", "log in your path")
+		secret := flag.String("secret", "", "the secret shared by the cluster to sign the messages")
+		record := flag.String("record", "", "record the messages in your path")
 		flag.Parse()
 		common.InitLog(*verb, *filename)
+		if *secret != "" {
+			SetClusterSecret([]byte(*secret))
+		}
+		if *record != "" {
+			recorder, err := core.CreateRecorder(*record)
+			common.ErrQuit(err, "Create Recorder")
+			SetRecorder(recorder)
+		}
 	}
 }
 
@@ -6,6 +6,7 @@ import (
 	common "github.com/gargous/flitter/common"
 	core "github.com/gargous/flitter/core"
 	//"strings"
+	"sync/atomic"
 	"time"
 )
 
@@ -14,12 +15,16 @@ type HeartbeatService interface {
 }
 type heartbeatsrv struct {
 	worker Worker
+	//the id of the heartbeat probe waiting for the leader
+	probing uint64
 	baseService
 }
 
 func NewHeartbeatService() HeartbeatService {
 	service := &heartbeatsrv{}
 	service.looper = core.NewMessageLooper(__LooperSize)
+	service.looper.Use(core.Recover(), core.LogErrors("[heartbeat server]"))
+	service.looper.SetBackpressure(__LooperBackpressure)
 	return service
 }
 func (h *heartbeatsrv) Init(srv interface{}) error {
@@ -53,6 +58,7 @@ func (h *heartbeatsrv) HandleMessages() {
 			if !ok {
 				msgInfo := msg.GetInfo()
 				msgInfo.SetState(core.MS_Succeed)
+				msgInfo.SetReplyTo(msgInfo.GetID())
 				h.looper.Push(core.NewMessage(msgInfo))
 			} else {
 				msg.GetInfo().SetState(core.MS_Ask)
@@ -76,49 +82,62 @@ func (h *heartbeatsrv) HandleMessages() {
 			}
 			msgInfo := msg.GetInfo()
 			msgInfo.SetState(core.MS_Succeed)
+			msgInfo.SetReplyTo(msgInfo.GetID())
 			h.looper.Push(core.NewMessage(msgInfo))
 
 			msgInfo = core.NewMessageInfo()
 			msgInfo.SetAcion(core.MA_Heartbeat)
-			msgInfo.SetState(core.MS_Probe)
-			h.looper.Push(core.NewMessage(msgInfo))
+			h.probe(core.NewMessage(msgInfo))
 
 		case core.MS_Succeed:
 			h.worker.SendService(ST_Watch, msg)
-		case core.MS_Error:
-			common.ErrIn(errors.New(msg.GetInfo().String()), "[heartbeat server when init]")
 		}
 		return
 	})
-	h.looper.AddHandler(5000, core.MA_Heartbeat, func(msg core.Message) (err error) {
+	h.looper.AddRetryHandler(core.RetryPolicy{
+		Timeout:     5000,
+		MaxAttempts: 3,
+		GiveUp: func(msg core.Message) error {
+			common.Logf(common.Errf, "Heartbeating maybe dead and now %v", msg)
+			return nil
+		},
+	}, core.MA_Heartbeat, func(msg core.Message) (err error) {
 		_, state, _ := msg.GetInfo().Info()
 		switch state {
 		case core.MS_Succeed:
-			msg.GetInfo().SetTime(time.Now())
+			msg.GetInfo().SetTime(h.looper.Clock().Now())
 			//common.Logf(common.Infof, "Heartbeating succeed and now %v", msg)
-			msg.GetInfo().SetState(core.MS_Probe)
-			h.looper.Push(msg)
+			h.probe(msg)
 		case core.MS_Failed:
-			msg.GetInfo().SetTime(time.Now())
+			msg.GetInfo().SetTime(h.looper.Clock().Now())
 			common.Logf(common.Warningf, "Heartbeating faild and now %v", msg)
-			if msg.GetVisitTimes() < 3 {
-				msg.GetInfo().SetState(core.MS_Probe)
-				h.looper.Push(msg)
-			} else {
-				common.Logf(common.Errf, "Heartbeating maybe dead and now %v", msg)
-			}
-
-		case core.MS_Error:
-			common.ErrIn(errors.New(msg.GetInfo().String()), "[heartbeat server when heartbeating]")
+			h.looper.Retry(msg)
 		}
 		return
 	})
 }
+
+/** the leader publishes its heartbeats to every follower, so the one received replies to the probe waiting */
+func (h *heartbeatsrv) Push(msg core.Message) {
+	action, state, _ := msg.GetInfo().Info()
+	if action == core.MA_Heartbeat && state == core.MS_Succeed && msg.GetInfo().GetReplyTo() == 0 {
+		msg.GetInfo().SetReplyTo(atomic.LoadUint64(&h.probing))
+	}
+	h.baseService.Push(msg)
+}
+
+/** probe for the next heartbeat of the leader */
+func (h *heartbeatsrv) probe(msg core.Message) {
+	msg.GetInfo().SetState(core.MS_Probe)
+	msg.GetInfo().SetReplyTo(0)
+	atomic.StoreUint64(&h.probing, msg.GetInfo().GetID())
+	h.looper.Push(msg)
+}
 func (h *heartbeatsrv) Start() {
 	h.looper.Loop()
 }
 func (h *heartbeatsrv) Term() {
-	h.Term()
+	h.looper.Term()
 }
 func (h heartbeatsrv) String() string {
 	str := fmt.Sprintf("Heartbeat Service:["+"looper:%p"+"]", h.looper)
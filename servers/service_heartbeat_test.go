@@ -0,0 +1,76 @@
+package servers
+
+import (
+	common "github.com/gargous/flitter/common"
+	core "github.com/gargous/flitter/core"
+	"testing"
+	"time"
+)
+
+/*a worker at path, keeping what the services send instead of sending it*/
+type stubWorker struct {
+	Worker
+	path      core.NodePath
+	sent      chan core.Message
+	published chan core.Message
+}
+
+func newStubWorker(path core.NodePath) *stubWorker {
+	return &stubWorker{
+		path:      path,
+		sent:      make(chan core.Message, 10),
+		published: make(chan core.Message, 10),
+	}
+}
+func (s *stubWorker) GetPath() core.NodePath { return s.path }
+func (s *stubWorker) SendToWroker(msg core.Message, npath core.NodePath) error {
+	s.sent <- msg.Copy()
+	return nil
+}
+func (s *stubWorker) PublishToWorker(msg core.Message) error {
+	s.published <- msg.Copy()
+	return nil
+}
+func (s *stubWorker) IsLocked(name string, key string) bool { return false }
+func (s *stubWorker) Lock(name string, key string) bool     { return true }
+func (s *stubWorker) Unlock(key string)                     {}
+
+/** wait until the service handled count messages of action in state */
+func waitHandled(t *testing.T, srvice Service, action core.MessageAction, state core.MessageState, count uint64) {
+	for start := time.Now(); time.Since(start) < time.Second; time.Sleep(time.Millisecond) {
+		if srvice.Stats().Actions[action].Handled[state] >= count {
+			return
+		}
+	}
+	t.Fatal(common.Errf("Not handled %v %v:%v", action, state, srvice.Stats()))
+}
+
+func Test_HeartbeatSettle(t *testing.T) {
+	t.Log(common.Norf("Start Heartbeat Settle"))
+	clock := core.NewFakeClock(time.Unix(0, 0))
+	srvice := NewHeartbeatService().(*heartbeatsrv)
+	srvice.SetClock(clock)
+	srvice.HandleMessages()
+	go srvice.Start()
+	defer srvice.Term()
+
+	info := core.NewMessageInfo()
+	info.SetAcion(core.MA_Heartbeat)
+	srvice.probe(core.NewMessage(info))
+	for beat := uint64(1); beat <= 3; beat++ {
+		waitHandled(t, srvice, core.MA_Heartbeat, core.MS_Probe, beat)
+		//published by the leader, knowing nothing of the probe
+		info := core.NewMessageInfo()
+		info.SetAcion(core.MA_Heartbeat)
+		info.SetState(core.MS_Succeed)
+		srvice.Push(core.NewMessage(info))
+		waitHandled(t, srvice, core.MA_Heartbeat, core.MS_Succeed, beat)
+		clock.Advance(3000 * time.Millisecond)
+	}
+	waitHandled(t, srvice, core.MA_Heartbeat, core.MS_Probe, 4)
+	stats := srvice.Stats().Actions[core.MA_Heartbeat]
+	if stats.Handled[core.MS_Failed] != 0 || stats.Retries != 0 || clock.Pending() != 1 {
+		t.Fatal(common.Errf("Probes not settled:%v pending:%d", srvice.Stats(), clock.Pending()))
+	}
+	t.Log(common.Norf("End Heartbeat Settle"))
+}
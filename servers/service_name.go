@@ -3,7 +3,6 @@ package servers
 import (
 	"errors"
 	"fmt"
-	"github.com/gargous/flitter/common"
 	"github.com/gargous/flitter/core"
 	//saver "github.com/gargous/flitter/save"
 	socketio "github.com/googollee/go-socket.io"
@@ -28,6 +27,8 @@ func NewNameService() NameService {
 		bussyness: false,
 	}
 	srv.looper = core.NewMessageLooper(__LooperSize)
+	srv.looper.Use(core.Recover(), core.LogErrors("[node server]"))
+	srv.looper.SetBackpressure(__LooperBackpressure)
 	return srv
 }
 
@@ -108,13 +109,12 @@ func (n *namesrv) HandleMessages() {
 				msg.ClearContent()
 				msg.AppendContent([]byte(nodeinfo))
 				msg.GetInfo().SetState(core.MS_Succeed)
+				msg.GetInfo().SetReplyTo(msg.GetInfo().GetID())
 				err = n.referee.SendToWroker(msg, nodeinfo)
 				if err != nil {
 					return err
 				}
 			}
-		case core.MS_Error:
-			common.ErrIn(errors.New(msg.GetInfo().String()), "[node server]")
 		}
 		return nil
 	})
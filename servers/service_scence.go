@@ -8,7 +8,6 @@ import (
 	socketio "github.com/googollee/go-socket.io"
 	"strings"
 	"sync"
-	"time"
 )
 
 type ScenceService interface {
@@ -31,11 +30,23 @@ type scencesrvice struct {
 	clients        map[string]common.DataSet
 }
 
+/*the messages of one client are handled in order, the ones of different clients in parallel*/
+const __ScenceWorkers int = 4
+
 func NewScenceService() ScenceService {
 	service := &scencesrvice{}
 	service.accessable = false
 	service.clients = make(map[string]common.DataSet)
 	service.looper = core.NewMessageLooper(__LooperSize)
+	service.looper.Use(core.Recover(), core.LogErrors("[scence server]"))
+	service.looper.SetBackpressure(__LooperBackpressure)
+	service.looper.SetWorkers(__ScenceWorkers, func(msg core.Message) string {
+		var clientInfo core.ClientInfo
+		if !clientInfo.Parse(msg) {
+			return ""
+		}
+		return clientInfo.GetName()
+	})
 	return service
 }
 func (s *scencesrvice) IsAccess() bool {
@@ -115,43 +126,31 @@ func (s *scencesrvice) lockClientData(cInfo core.ClientInfo, dInfo core.DataInfo
 
 //outside involk only
 func (s *scencesrvice) LockClientData(cInfo core.ClientInfo, dInfo core.DataInfo) (err error) {
-	info := core.NewMessageInfo()
-	info.SetAcion(core.MA_Lock)
-	info.SetState(core.MS_Probe)
-	msg := core.NewMessage(info)
-	cInfo.AppendToMsg(msg)
-	err = dInfo.AppendToMsg(msg)
-	if err != nil {
-		return
-	}
-	s.looper.Push(msg)
-	return
+	return s.probeClientData(core.MA_Lock, cInfo, dInfo, 0)
 }
 
 //outside involk only
 func (s *scencesrvice) UnlockClientData(cInfo core.ClientInfo, dInfo core.DataInfo) (err error) {
-	info := core.NewMessageInfo()
-	info.SetAcion(core.MA_Unlock)
-	info.SetState(core.MS_Probe)
-	msg := core.NewMessage(info)
-	cInfo.AppendToMsg(msg)
-	err = dInfo.AppendToMsg(msg)
-	if err != nil {
-		return
-	}
-	s.looper.Push(msg)
-	return
+	return s.probeClientData(core.MA_Unlock, cInfo, dInfo, 0)
 }
 
 //outside involk only
 func (s *scencesrvice) UpdateClientData(cInfo core.ClientInfo, dInfo core.DataInfo) (err error) {
-	data, ok := s.clients[cInfo.GetName()]
-	if ok {
-		dInfo.Value = data.Grant(dInfo.Key, dInfo.Value)
+	return s.probeClientData(core.MA_Update, cInfo, dInfo, 0)
+}
+
+/** the leader probes again for the ask of a follower, its succeed replies to the ask so the probe of the follower settles */
+func (s *scencesrvice) probeClientData(action core.MessageAction, cInfo core.ClientInfo, dInfo core.DataInfo, replyTo uint64) (err error) {
+	if action == core.MA_Update {
+		data, ok := s.clients[cInfo.GetName()]
+		if ok {
+			dInfo.Value = data.Grant(dInfo.Key, dInfo.Value)
+		}
 	}
 	info := core.NewMessageInfo()
-	info.SetAcion(core.MA_Update)
+	info.SetAcion(action)
 	info.SetState(core.MS_Probe)
+	info.SetReplyTo(replyTo)
 	msg := core.NewMessage(info)
 	cInfo.AppendToMsg(msg)
 	err = dInfo.AppendToMsg(msg)
@@ -241,12 +240,44 @@ func (s *scencesrvice) HandleClients() {
 			s.SetServerData(serverInfo)
 		}
 	})
+	s.worker.OnClient("flitter get", func(so socketio.Socket) interface{} {
+		return func(name string, key string, contentType string) {
+			var cInfo core.ClientInfo
+			if name != "" {
+				cInfo = core.NewClientInfo(name, s.worker.GetPath())
+			}
+			values := s.GetClientData(cInfo, core.NewDataInfo(key))
+			replies := make(map[string]interface{})
+			for cname, value := range values {
+				converted, err := value.Convert(contentType)
+				if err != nil {
+					common.ErrIn(err, "Client Get "+key)
+					continue
+				}
+				if converted.ContentType == common.CT_JSON || converted.ContentType == common.CT_Raw {
+					replies[cname] = string(converted.Data)
+				} else {
+					replies[cname] = converted.Data
+				}
+			}
+			so.Emit("flitter get", key, replies)
+		}
+	})
 }
 
 var (
 	__err_Not_Catch_Lock error = errors.New("Not_Catch_Lock")
 )
 
+/*the lock, unlock and update probes are tried again from 1s on, five times before going to the dead letters*/
+var __scenceRetry core.RetryPolicy = core.RetryPolicy{
+	Timeout:     3000,
+	MaxAttempts: 5,
+	Backoff:     1000,
+	MaxBackoff:  8000,
+	Jitter:      0.2,
+}
+
 func (s scencesrvice) ThatIsMe(cname string) (ok bool, err error) {
 	ninfo, ok := core.NodePath(s.worker.GetPath()).GetNodeInfo()
 	if !ok {
@@ -372,21 +403,15 @@ func (s *scencesrvice) HandleMessages() {
 			}
 		case core.MS_Failed:
 			common.Logf(common.Warningf, "%v Faild And Now %v", action, msg)
-			time.AfterFunc(time.Second, func() {
-				msg.GetInfo().SetTime(time.Now())
-				msg.GetInfo().SetState(core.MS_Probe)
-				s.looper.Push(msg)
-			})
-		case core.MS_Error:
-			common.ErrIn(errors.New(msg.String()))
+			s.looper.Retry(msg)
 		}
 		return
 	}
-	s.looper.AddHandler(3000, core.MA_Lock, func(msg core.Message) (err error) {
+	s.looper.AddRetryHandler(__scenceRetry, core.MA_Lock, func(msg core.Message) (err error) {
 		return broadcast(
 			msg,
 			func(cInfo core.ClientInfo, dInfo core.DataInfo) (err error) {
-				return s.LockClientData(cInfo, dInfo)
+				return s.probeClientData(core.MA_Lock, cInfo, dInfo, msg.GetInfo().GetID())
 			},
 			func(cInfo core.ClientInfo, dInfo core.DataInfo) (err error) {
 				ok := dInfo.AssertCount(
@@ -431,11 +456,11 @@ func (s *scencesrvice) HandleMessages() {
 			},
 		)
 	})
-	s.looper.AddHandler(3000, core.MA_Unlock, func(msg core.Message) (err error) {
+	s.looper.AddRetryHandler(__scenceRetry, core.MA_Unlock, func(msg core.Message) (err error) {
 		return broadcast(
 			msg,
 			func(cInfo core.ClientInfo, dInfo core.DataInfo) (err error) {
-				return s.UnlockClientData(cInfo, dInfo)
+				return s.probeClientData(core.MA_Unlock, cInfo, dInfo, msg.GetInfo().GetID())
 			},
 			func(cInfo core.ClientInfo, dInfo core.DataInfo) (err error) {
 				dInfo.AssertCount(
@@ -463,11 +488,11 @@ func (s *scencesrvice) HandleMessages() {
 			},
 		)
 	})
-	s.looper.AddHandler(3000, core.MA_Update, func(msg core.Message) (err error) {
+	s.looper.AddRetryHandler(__scenceRetry, core.MA_Update, func(msg core.Message) (err error) {
 		return broadcast(
 			msg,
 			func(cInfo core.ClientInfo, dInfo core.DataInfo) error {
-				return s.UpdateClientData(cInfo, dInfo)
+				return s.probeClientData(core.MA_Update, cInfo, dInfo, msg.GetInfo().GetID())
 			},
 			func(cInfo core.ClientInfo, dInfo core.DataInfo) error {
 				dInfo.AssertCount(
@@ -496,7 +521,7 @@ func (s *scencesrvice) Start() {
 	s.looper.Loop()
 }
 func (s *scencesrvice) Term() {
-	s.Term()
+	s.looper.Term()
 }
 func (s scencesrvice) ClientsString() (str string) {
 	str = ""
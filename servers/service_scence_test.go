@@ -2,6 +2,7 @@ package servers
 
 import (
 	common "github.com/gargous/flitter/common"
+	core "github.com/gargous/flitter/core"
 	"testing"
 	"time"
 )
@@ -22,3 +23,42 @@ func Test_Watch(t *testing.T) {
 	}
 	t.Log(common.Norf("End Watch"))
 }
+
+func Test_ScenceSettle(t *testing.T) {
+	t.Log(common.Norf("Start Scence Settle"))
+	newScence := func(path core.NodePath) (*scencesrvice, *stubWorker, *core.FakeClock) {
+		clock := core.NewFakeClock(time.Unix(0, 0))
+		worker := newStubWorker(path)
+		srvice := NewScenceService().(*scencesrvice)
+		srvice.SetClock(clock)
+		srvice.worker = worker
+		srvice.HandleMessages()
+		go srvice.Start()
+		return srvice, worker, clock
+	}
+	leader, leaderWorker, _ := newScence("leader@127.0.0.1:8900")
+	defer leader.Term()
+	follower, followerWorker, clock := newScence("leader@127.0.0.1:8900/follower@127.0.0.1:8910")
+	defer follower.Term()
+
+	cInfo := core.NewClientInfo("client", followerWorker.GetPath())
+	err := follower.UnlockClientData(cInfo, core.NewDataInfo("hp"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ask := <-followerWorker.sent
+	leader.Push(ask)
+	//the leader probes again, its succeed replying to the ask
+	reply := <-leaderWorker.published
+	if reply.GetInfo().GetReplyTo() != ask.GetInfo().GetID() {
+		t.Fatal(common.Errf("Not replied to %d:%v", ask.GetInfo().GetID(), reply))
+	}
+	follower.Push(reply)
+	waitHandled(t, follower, core.MA_Unlock, core.MS_Succeed, 1)
+	clock.Advance(time.Duration(__scenceRetry.Timeout) * time.Millisecond)
+	stats := follower.Stats().Actions[core.MA_Unlock]
+	if stats.Handled[core.MS_Failed] != 0 || stats.Retries != 0 || clock.Pending() != 0 {
+		t.Fatal(common.Errf("Probe not settled:%v pending:%d", follower.Stats(), clock.Pending()))
+	}
+	t.Log(common.Norf("End Scence Settle"))
+}
@@ -6,7 +6,6 @@ import (
 	common "github.com/gargous/flitter/common"
 	core "github.com/gargous/flitter/core"
 	//"os"
-	"time"
 )
 
 type WatchService interface {
@@ -26,6 +25,8 @@ func NewWatchService() WatchService {
 		refereeServerIndex: 0,
 	}
 	_watchsrv.looper = core.NewMessageLooper(__LooperSize)
+	_watchsrv.looper.Use(core.Recover(), core.LogErrors("[watch server]"))
+	_watchsrv.looper.SetBackpressure(__LooperBackpressure)
 	return _watchsrv
 }
 func (w *watchsrv) ConfigRefereeServer(npath core.NodePath) {
@@ -66,11 +67,7 @@ func (w *watchsrv) HandleMessages() {
 		case core.MS_Failed:
 			msg.ClearContent()
 			common.Logf(common.Warningf, "%v[watch server failed]", msg.GetInfo())
-			msg.GetInfo().SetTime(time.Now())
-			msg.GetInfo().SetState(core.MS_Probe)
-			w.looper.Push(msg)
-		case core.MS_Error:
-			common.ErrIn(errors.New(msg.String()), "watch server")
+			w.looper.Retry(msg)
 		}
 		return
 	})
@@ -85,11 +82,7 @@ func (w *watchsrv) HandleMessages() {
 		case core.MS_Succeed:
 			common.Logf(common.Infof, "Access")
 		case core.MS_Failed:
-			msg.GetInfo().SetTime(time.Now())
-			msg.GetInfo().SetState(core.MS_Probe)
-			w.looper.Push(msg)
-		case core.MS_Error:
-			common.ErrIn(errors.New(msg.GetInfo().String()), "Init")
+			w.looper.Retry(msg)
 		}
 		return
 	})
@@ -98,7 +91,7 @@ func (w *watchsrv) Start() {
 	info := core.NewMessageInfo()
 	info.SetAcion(core.MA_Refer)
 	info.SetState(core.MS_Probe)
-	info.SetTime(time.Now())
+	info.SetTime(w.looper.Clock().Now())
 	w.looper.Push(core.NewMessage(info))
 	w.looper.Loop()
 }
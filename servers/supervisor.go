@@ -0,0 +1,131 @@
+package servers
+
+import (
+	"fmt"
+	"github.com/gargous/flitter/common"
+	"github.com/gargous/flitter/core"
+	"sync"
+	"time"
+)
+
+type RestartStrategy uint8
+
+const (
+	_ RestartStrategy = iota
+	//a crashed service stays down
+	RS_Never
+	//a crashed service starts again at once
+	RS_Immediate
+	//a crashed service starts again after a wait doubled for every restart in the period
+	RS_Backoff
+)
+
+func (r RestartStrategy) String() string {
+	switch r {
+	case RS_Never:
+		return "RS_Never"
+	case RS_Immediate:
+		return "RS_Immediate"
+	case RS_Backoff:
+		return "RS_Backoff"
+	}
+	return ""
+}
+
+/*
+how the supervisor starts a crashed service again,
+more than MaxRestarts crashes in Period make the server unhealthy,
+the durations are in milliseconds
+*/
+type RestartPolicy struct {
+	Strategy    RestartStrategy
+	MaxRestarts int
+	Period      time.Duration
+	Backoff     time.Duration
+	MaxBackoff  time.Duration
+}
+
+var __DefaultRestart RestartPolicy = RestartPolicy{
+	Strategy:    RS_Backoff,
+	MaxRestarts: 5,
+	Period:      60000,
+	Backoff:     100,
+	MaxBackoff:  5000,
+}
+
+/*recover the panics of the services and start them again by the policy*/
+type Supervisor interface {
+	Supervise(st ServiceType, srvice Service)
+	Healthy() bool
+	Failures() map[ServiceType]error
+}
+
+func NewSupervisor(policy RestartPolicy) Supervisor {
+	return &supervisor{
+		policy:   policy,
+		failures: make(map[ServiceType]error),
+	}
+}
+
+type supervisor struct {
+	policy   RestartPolicy
+	mutex    sync.Mutex
+	failures map[ServiceType]error
+}
+
+/** block until the service returns by itself or is given up */
+func (s *supervisor) Supervise(st ServiceType, srvice Service) {
+	crashes := []time.Time{}
+	for {
+		err := s.run(srvice)
+		if err == nil {
+			return
+		}
+		common.ErrIn(err, "[supervisor]", st.String())
+		now := time.Now()
+		crashes = append(crashes, now)
+		for len(crashes) > 0 && now.Sub(crashes[0]) > s.policy.Period*time.Millisecond {
+			crashes = crashes[1:]
+		}
+		if s.policy.Strategy == RS_Never || len(crashes) > s.policy.MaxRestarts {
+			s.mutex.Lock()
+			s.failures[st] = err
+			s.mutex.Unlock()
+			common.Logf(common.Errf, "%v Given Up After %d Crashes", st, len(crashes))
+			return
+		}
+		if s.policy.Strategy == RS_Backoff {
+			backoff := core.RetryPolicy{Backoff: s.policy.Backoff, MaxBackoff: s.policy.MaxBackoff}
+			time.Sleep(backoff.Delay(len(crashes)))
+		}
+		common.Logf(common.Warningf, "Restart %v", st)
+	}
+}
+
+func (s *supervisor) run(srvice Service) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("Panic %v", r)
+		}
+	}()
+	srvice.Start()
+	return
+}
+
+/** false once a service is given up */
+func (s *supervisor) Healthy() bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return len(s.failures) <= 0
+}
+
+/** the last crash of every service given up */
+func (s *supervisor) Failures() map[ServiceType]error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	failures := make(map[ServiceType]error, len(s.failures))
+	for st, err := range s.failures {
+		failures[st] = err
+	}
+	return failures
+}
@@ -0,0 +1,74 @@
+package servers
+
+import (
+	common "github.com/gargous/flitter/common"
+	core "github.com/gargous/flitter/core"
+	"testing"
+	"time"
+)
+
+type crashService struct {
+	crashes int
+	starts  int
+}
+
+func (c *crashService) Init(srv interface{}) error { return nil }
+func (c *crashService) Start() {
+	c.starts++
+	if c.starts <= c.crashes {
+		panic("crash")
+	}
+}
+func (c *crashService) Term()                             {}
+func (c *crashService) Drain(timeout time.Duration) error { return nil }
+func (c *crashService) Push(msg core.Message)             {}
+func (c *crashService) DeadLetters() core.DeadLetters     { return nil }
+func (c *crashService) SetClock(clock core.Clock)         {}
+func (c *crashService) Stats() core.LooperStats           { return core.LooperStats{} }
+func (c *crashService) String() string                    { return "Crash Service" }
+
+func Test_Supervisor(t *testing.T) {
+	t.Log(common.Norf("Start Supervisor"))
+	supervisor := NewSupervisor(RestartPolicy{Strategy: RS_Backoff, MaxRestarts: 3, Period: 1000, Backoff: 1})
+	srvice := &crashService{crashes: 2}
+	supervisor.Supervise(ST_Watch, srvice)
+	if srvice.starts != 3 || !supervisor.Healthy() {
+		t.Fatal(common.Errf("Not Restarted:%d", srvice.starts))
+	}
+	srvice = &crashService{crashes: 10}
+	supervisor.Supervise(ST_Scence, srvice)
+	if srvice.starts != 4 || supervisor.Healthy() {
+		t.Fatal(common.Errf("Not Given Up:%d", srvice.starts))
+	}
+	if _, ok := supervisor.Failures()[ST_Scence]; !ok {
+		t.Fatal(common.Errf("No Failure Of %v", ST_Scence))
+	}
+	never := NewSupervisor(RestartPolicy{Strategy: RS_Never})
+	srvice = &crashService{crashes: 1}
+	never.Supervise(ST_Name, srvice)
+	if srvice.starts != 1 || never.Healthy() {
+		t.Fatal(common.Errf("Restarted:%d", srvice.starts))
+	}
+	t.Log(common.Norf("End Supervisor"))
+}
+
+func Test_SupervisorShutdown(t *testing.T) {
+	t.Log(common.Norf("Start Supervisor Shutdown"))
+	srvice := NewWatchService()
+	server := &baseServer{srvices: map[ServiceType]Service{ST_Watch: srvice}}
+	supervised := make(chan bool)
+	go func() {
+		NewSupervisor(__DefaultRestart).Supervise(ST_Watch, srvice)
+		close(supervised)
+	}()
+	time.Sleep(10 * time.Millisecond)
+	if err := server.Shutdown(1000); err != nil {
+		t.Fatal(common.Errf("Shutdown err:%v", err))
+	}
+	select {
+	case <-supervised:
+	case <-time.After(time.Second):
+		t.Fatal(common.Errf("Still supervised after the shutdown"))
+	}
+	t.Log(common.Norf("End Supervisor Shutdown"))
+}